@@ -0,0 +1,224 @@
+package agents
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Antonite/oware_rl/alphazero"
+	"github.com/Antonite/oware_rl/mcts"
+	"github.com/Antonite/oware_rl/minimax"
+	"github.com/Antonite/oware_rl/player"
+	"github.com/Antonite/oware_rl/qdeepneuro"
+	"github.com/Antonite/oware_rl/qtable"
+	"github.com/Antonite/oware_rl/storage"
+)
+
+// Usage lists the agent specs understood by New
+const Usage = "random, qtable, qdeepneuro[:checkpoint], minimax:depth[:score|qtable|qdeepneuro[:timelimit]], mcts:simulations[:random|qtable|qdeepneuro[:timelimit]], alphazero:file[:simulations], actorcritic:checkpoint"
+
+// Env holds resources shared by agents. The store is opened on first use.
+type Env struct {
+	Backend string
+	Path    string
+	// Checkpoint used by qdeepneuro agents, guides and evaluators that don't name one.
+	// They play with untrained weights when it is empty.
+	Network string
+	store   storage.Storage
+}
+
+// NewEnv shares an already open store with the agents
+func NewEnv(store storage.Storage) *Env {
+	return &Env{store: store}
+}
+
+func (e *Env) Store() (storage.Storage, error) {
+	if e.store != nil {
+		return e.store, nil
+	}
+
+	s, err := storage.Open(e.Backend, e.Path, 50)
+	if err != nil {
+		return nil, err
+	}
+
+	e.store = s
+	return s, nil
+}
+
+// deepPlayer loads a qdeepneuro checkpoint, the env's when path is empty
+func (e *Env) deepPlayer(path string) (*qdeepneuro.Player, error) {
+	if path == "" {
+		path = e.Network
+	}
+
+	if path == "" {
+		return qdeepneuro.NewPlayer(qdeepneuro.DefaultConfig()), nil
+	}
+
+	return qdeepneuro.LoadPlayer(path)
+}
+
+func (e *Env) Close() {
+	if e.store != nil {
+		e.store.Close()
+	}
+}
+
+// New builds a player from a spec of the form name[:arguments]
+func New(spec string, env *Env) (player.Player, error) {
+	name, arg := split(spec)
+	switch name {
+	case "random":
+		return player.NewRandom(), nil
+	case "qtable":
+		store, err := env.Store()
+		if err != nil {
+			return nil, err
+		}
+		return qtable.NewPlayer(store, qtable.DefaultConfig()), nil
+	case "qdeepneuro":
+		return env.deepPlayer(arg)
+	case "minimax":
+		return newMinimax(arg, env)
+	case "mcts":
+		return newMCTS(arg, env)
+	case "alphazero":
+		return newAlphaZero(arg)
+	case "actorcritic":
+		return qdeepneuro.LoadPolicyPlayer(arg)
+	default:
+		return nil, fmt.Errorf("unknown agent: %s. known agents: %s", spec, Usage)
+	}
+}
+
+// newMinimax parses depth[:evaluator[:timelimit]]
+func newMinimax(arg string, env *Env) (player.Player, error) {
+	config := minimax.Config{Depth: 4}
+	parts := strings.Split(arg, ":")
+	if parts[0] != "" {
+		depth, err := strconv.Atoi(parts[0])
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("invalid minimax depth: %s", parts[0])
+		}
+		config.Depth = depth
+	}
+
+	if len(parts) > 1 {
+		eval, err := newEvaluator(parts[1], env)
+		if err != nil {
+			return nil, err
+		}
+		config.Evaluator = eval
+	}
+
+	if len(parts) > 2 {
+		limit, err := time.ParseDuration(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimax time limit: %s", parts[2])
+		}
+		config.TimeLimit = limit
+	}
+
+	return minimax.New(config), nil
+}
+
+// newMCTS parses simulations[:guide[:timelimit]]. Guided searches use the guide's evaluation of
+// every move as priors and of leaves as values instead of random rollouts.
+func newMCTS(arg string, env *Env) (player.Player, error) {
+	config := mcts.DefaultConfig()
+	parts := strings.Split(arg, ":")
+	if parts[0] != "" {
+		sims, err := strconv.Atoi(parts[0])
+		if err != nil || sims < 1 {
+			return nil, fmt.Errorf("invalid mcts simulations: %s", parts[0])
+		}
+		config.Simulations = sims
+	}
+
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "random":
+		case "qtable":
+			store, err := env.Store()
+			if err != nil {
+				return nil, err
+			}
+			eval := qtable.NewEvaluator(store, qtable.DefaultConfig())
+			config.Prior = &mcts.ValuePrior{Evaluator: eval, Temperature: 1}
+			config.Evaluator = eval
+		case "qdeepneuro":
+			p, err := env.deepPlayer("")
+			if err != nil {
+				return nil, err
+			}
+			config.Prior = &mcts.ValuePrior{Evaluator: p, Temperature: 1}
+			config.Evaluator = p
+		default:
+			return nil, fmt.Errorf("unknown mcts guide: %s", parts[1])
+		}
+	}
+
+	if len(parts) > 2 {
+		limit, err := time.ParseDuration(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mcts time limit: %s", parts[2])
+		}
+		config.TimeLimit = limit
+	}
+
+	return mcts.New(config), nil
+}
+
+// newAlphaZero parses file[:simulations]
+func newAlphaZero(arg string) (player.Player, error) {
+	parts := strings.Split(arg, ":")
+	f, err := os.Open(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n, err := alphazero.Load(f)
+	if err != nil {
+		return nil, err
+	}
+
+	sims := 200
+	if len(parts) > 1 {
+		sims, err = strconv.Atoi(parts[1])
+		if err != nil || sims < 1 {
+			return nil, fmt.Errorf("invalid alphazero simulations: %s", parts[1])
+		}
+	}
+
+	return alphazero.NewPlayer(n, sims), nil
+}
+
+func newEvaluator(name string, env *Env) (minimax.Evaluator, error) {
+	switch name {
+	case "score":
+		return minimax.ScoreDifference{}, nil
+	case "qtable":
+		store, err := env.Store()
+		if err != nil {
+			return nil, err
+		}
+		return qtable.NewEvaluator(store, qtable.DefaultConfig()), nil
+	case "qdeepneuro":
+		return env.deepPlayer("")
+	default:
+		return nil, fmt.Errorf("unknown evaluator: %s", name)
+	}
+}
+
+func split(spec string) (string, string) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
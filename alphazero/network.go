@@ -0,0 +1,326 @@
+package alphazero
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+
+	"github.com/Antonite/oware"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// Own pits, opponent pits and both scores, always from the side to move
+	inputCount = 14
+	// One policy output per pit of the side to move
+	policyCount = 6
+
+	networkMagic   = "OWAREAZN"
+	networkVersion = uint16(1)
+)
+
+// Network is a policy-value network with two hidden ReLU layers, a softmax policy head
+// over the six pits of the side to move and a tanh value head
+type Network struct {
+	w1, w2, wp, wv *mat.Dense
+	b1, b2, bp, bv []float64
+}
+
+func NewNetwork(hidden int, r *rand.Rand) *Network {
+	return &Network{
+		w1: randomDense(r, inputCount, hidden),
+		w2: randomDense(r, hidden, hidden),
+		wp: randomDense(r, hidden, policyCount),
+		wv: randomDense(r, hidden, 1),
+		b1: make([]float64, hidden),
+		b2: make([]float64, hidden),
+		bp: make([]float64, policyCount),
+		bv: make([]float64, 1),
+	}
+}
+
+func (n *Network) Clone() *Network {
+	return &Network{
+		w1: mat.DenseCopyOf(n.w1),
+		w2: mat.DenseCopyOf(n.w2),
+		wp: mat.DenseCopyOf(n.wp),
+		wv: mat.DenseCopyOf(n.wv),
+		b1: append([]float64{}, n.b1...),
+		b2: append([]float64{}, n.b2...),
+		bp: append([]float64{}, n.bp...),
+		bv: append([]float64{}, n.bv...),
+	}
+}
+
+// PriorValue returns move probabilities over the valid pits and the value for the player to move.
+// It only reads the weights and is safe for concurrent use.
+func (n *Network) PriorValue(b *oware.Board) (map[int]float64, float64) {
+	x := mat.NewDense(1, inputCount, encode(b))
+	mask := mat.NewDense(1, policyCount, validMask(b))
+	f := n.forward(x, mask)
+
+	offset := b.Player() * policyCount
+	priors := make(map[int]float64, policyCount)
+	for _, m := range b.GetValidMoves() {
+		priors[m] = f.policy.At(0, m-offset)
+	}
+
+	return priors, f.value.At(0, 0)
+}
+
+// pass keeps the intermediate values of a forward pass for backpropagation
+type pass struct {
+	x, z1, a1, z2, a2 *mat.Dense
+	policy, value     *mat.Dense
+}
+
+func (n *Network) forward(x *mat.Dense, mask *mat.Dense) *pass {
+	rows, _ := x.Dims()
+	f := &pass{x: x}
+
+	f.z1 = affine(x, n.w1, n.b1)
+	f.a1 = mat.DenseCopyOf(f.z1)
+	f.a1.Apply(relu, f.a1)
+
+	f.z2 = affine(f.a1, n.w2, n.b2)
+	f.a2 = mat.DenseCopyOf(f.z2)
+	f.a2.Apply(relu, f.a2)
+
+	f.policy = affine(f.a2, n.wp, n.bp)
+	for i := 0; i < rows; i++ {
+		maskedSoftmax(f.policy.RawRowView(i), mask.RawRowView(i))
+	}
+
+	f.value = affine(f.a2, n.wv, n.bv)
+	f.value.Apply(func(i, j int, v float64) float64 { return math.Tanh(v) }, f.value)
+
+	return f
+}
+
+// train runs one gradient step on a batch and returns the policy and value losses.
+// The loss is policy cross entropy plus squared value error plus L2 weight decay.
+func (n *Network) train(x, mask, targetPolicy, targetValue *mat.Dense, learningRate float64, weightDecay float64) (float64, float64) {
+	rows, _ := x.Dims()
+	f := n.forward(x, mask)
+	scale := 1 / float64(rows)
+
+	policyLoss := 0.0
+	valueLoss := 0.0
+	for i := 0; i < rows; i++ {
+		for j := 0; j < policyCount; j++ {
+			if t := targetPolicy.At(i, j); t > 0 {
+				policyLoss -= t * math.Log(math.Max(f.policy.At(i, j), 1e-12))
+			}
+		}
+		d := f.value.At(i, 0) - targetValue.At(i, 0)
+		valueLoss += d * d
+	}
+
+	// Softmax with cross entropy
+	dPolicy := mat.NewDense(rows, policyCount, nil)
+	dPolicy.Sub(f.policy, targetPolicy)
+	dPolicy.Scale(scale, dPolicy)
+
+	// Squared error through tanh
+	dValue := mat.NewDense(rows, 1, nil)
+	dValue.Apply(func(i, j int, v float64) float64 {
+		out := f.value.At(i, 0)
+		return 2 * (out - targetValue.At(i, 0)) * (1 - out*out) * scale
+	}, dValue)
+
+	dA2 := mat.NewDense(rows, n.w2.RawMatrix().Cols, nil)
+	dA2.Mul(dPolicy, n.wp.T())
+	dV := mat.NewDense(rows, n.w2.RawMatrix().Cols, nil)
+	dV.Mul(dValue, n.wv.T())
+	dA2.Add(dA2, dV)
+	dZ2 := reluGrad(dA2, f.z2)
+
+	dA1 := mat.NewDense(rows, n.w1.RawMatrix().Cols, nil)
+	dA1.Mul(dZ2, n.w2.T())
+	dZ1 := reluGrad(dA1, f.z1)
+
+	step(n.wp, n.bp, f.a2, dPolicy, learningRate, weightDecay)
+	step(n.wv, n.bv, f.a2, dValue, learningRate, weightDecay)
+	step(n.w2, n.b2, f.a1, dZ2, learningRate, weightDecay)
+	step(n.w1, n.b1, f.x, dZ1, learningRate, weightDecay)
+
+	return policyLoss * scale, valueLoss * scale
+}
+
+type savedNetwork struct {
+	Hidden         int
+	W1, W2, Wp, Wv []float64
+	B1, B2, Bp, Bv []float64
+}
+
+// Save writes a magic and version header followed by the gob encoded weights
+func (n *Network) Save(w io.Writer) error {
+	header := make([]byte, len(networkMagic)+2)
+	copy(header, networkMagic)
+	binary.BigEndian.PutUint16(header[len(networkMagic):], networkVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	return gob.NewEncoder(w).Encode(&savedNetwork{
+		Hidden: n.w1.RawMatrix().Cols,
+		W1:     n.w1.RawMatrix().Data,
+		W2:     n.w2.RawMatrix().Data,
+		Wp:     n.wp.RawMatrix().Data,
+		Wv:     n.wv.RawMatrix().Data,
+		B1:     n.b1,
+		B2:     n.b2,
+		Bp:     n.bp,
+		Bv:     n.bv,
+	})
+}
+
+func Load(r io.Reader) (*Network, error) {
+	header := make([]byte, len(networkMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read network header: %w", err)
+	}
+
+	if string(header[:len(networkMagic)]) != networkMagic {
+		return nil, errors.New("not an alphazero network")
+	}
+
+	if v := binary.BigEndian.Uint16(header[len(networkMagic):]); v != networkVersion {
+		return nil, fmt.Errorf("unsupported network version: %v", v)
+	}
+
+	var s savedNetwork
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	h := s.Hidden
+	if h < 1 || len(s.W1) != inputCount*h || len(s.W2) != h*h || len(s.Wp) != h*policyCount || len(s.Wv) != h ||
+		len(s.B1) != h || len(s.B2) != h || len(s.Bp) != policyCount || len(s.Bv) != 1 {
+		return nil, errors.New("network shape doesn't match")
+	}
+
+	return &Network{
+		w1: mat.NewDense(inputCount, h, s.W1),
+		w2: mat.NewDense(h, h, s.W2),
+		wp: mat.NewDense(h, policyCount, s.Wp),
+		wv: mat.NewDense(h, 1, s.Wv),
+		b1: s.B1,
+		b2: s.B2,
+		bp: s.Bp,
+		bv: s.Bv,
+	}, nil
+}
+
+// encode describes the board from the perspective of the side to move
+func encode(b *oware.Board) []float64 {
+	p := b.Player()
+	pits := b.Pits()
+	scores := b.Scores()
+	inputs := make([]float64, 0, inputCount)
+	for i := 0; i < 12; i++ {
+		inputs = append(inputs, float64(pits[(i+6*p)%12])/12)
+	}
+	inputs = append(inputs, float64(scores[p])/25, float64(scores[(p+1)%2])/25)
+	return inputs
+}
+
+func validMask(b *oware.Board) []float64 {
+	mask := make([]float64, policyCount)
+	offset := b.Player() * policyCount
+	for _, m := range b.GetValidMoves() {
+		mask[m-offset] = 1
+	}
+	return mask
+}
+
+func affine(x *mat.Dense, w *mat.Dense, bias []float64) *mat.Dense {
+	rows, _ := x.Dims()
+	_, cols := w.Dims()
+	out := mat.NewDense(rows, cols, nil)
+	out.Mul(x, w)
+	for i := 0; i < rows; i++ {
+		row := out.RawRowView(i)
+		for j := range row {
+			row[j] += bias[j]
+		}
+	}
+	return out
+}
+
+// step applies a gradient step to a layer given its input and the gradient of its output
+func step(w *mat.Dense, bias []float64, input *mat.Dense, dOut *mat.Dense, learningRate float64, weightDecay float64) {
+	r, c := w.Dims()
+	dW := mat.NewDense(r, c, nil)
+	dW.Mul(input.T(), dOut)
+	w.Apply(func(i, j int, v float64) float64 {
+		return v - learningRate*(dW.At(i, j)+weightDecay*v)
+	}, w)
+
+	rows, _ := dOut.Dims()
+	for j := range bias {
+		sum := 0.0
+		for i := 0; i < rows; i++ {
+			sum += dOut.At(i, j)
+		}
+		bias[j] -= learningRate * sum
+	}
+}
+
+func reluGrad(dA *mat.Dense, z *mat.Dense) *mat.Dense {
+	out := mat.DenseCopyOf(dA)
+	out.Apply(func(i, j int, v float64) float64 {
+		if z.At(i, j) <= 0 {
+			return 0
+		}
+		return v
+	}, out)
+	return out
+}
+
+func maskedSoftmax(logits []float64, mask []float64) {
+	max := math.Inf(-1)
+	for i, v := range logits {
+		if mask[i] > 0 && v > max {
+			max = v
+		}
+	}
+
+	sum := 0.0
+	for i, v := range logits {
+		if mask[i] > 0 {
+			logits[i] = math.Exp(v - max)
+			sum += logits[i]
+		} else {
+			logits[i] = 0
+		}
+	}
+
+	if sum == 0 {
+		return
+	}
+	for i := range logits {
+		logits[i] /= sum
+	}
+}
+
+func relu(i, j int, v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+// randomDense uses He initialization
+func randomDense(r *rand.Rand, rows int, cols int) *mat.Dense {
+	data := make([]float64, rows*cols)
+	std := math.Sqrt(2 / float64(rows))
+	for i := range data {
+		data[i] = r.NormFloat64() * std
+	}
+	return mat.NewDense(rows, cols, data)
+}
@@ -0,0 +1,95 @@
+package alphazero
+
+import (
+	"math/rand"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/mcts"
+	"github.com/Antonite/oware_rl/player"
+)
+
+// Sample is one training position: the encoded board, the valid pits,
+// the search visit distribution and the final result for the side to move
+type Sample struct {
+	Input  []float64
+	Mask   []float64
+	Policy []float64
+	Value  float64
+}
+
+// NewPlayer searches with the network as priors and leaf values
+func NewPlayer(n *Network, simulations int) *mcts.Engine {
+	config := mcts.DefaultConfig()
+	config.Simulations = simulations
+	config.C = 1.5
+	config.Oracle = n
+	return mcts.New(config)
+}
+
+// selfPlay plays one game against itself and returns a sample for every position.
+// Moves are sampled in proportion to visits for the first tempMoves plies and are greedy afterwards.
+func selfPlay(n *Network, config Config, r *rand.Rand) ([]*Sample, error) {
+	mc := mcts.DefaultConfig()
+	mc.Simulations = config.Simulations
+	mc.C = config.C
+	mc.Oracle = n
+	mc.NoiseAlpha = config.NoiseAlpha
+	mc.NoiseWeight = config.NoiseWeight
+	engine := mcts.New(mc)
+
+	samples := []*Sample{}
+	sides := []int{}
+	g := player.NewGame(oware.Initialize())
+	for ply := 0; !g.Over(); ply++ {
+		// Shares of visits, or the network's priors when a single simulation visited nothing
+		shares, err := engine.Policy(g.Board)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := g.Board.Player() * policyCount
+		policy := make([]float64, policyCount)
+		for m, v := range shares {
+			policy[m-offset] = v
+		}
+
+		samples = append(samples, &Sample{
+			Input:  encode(g.Board),
+			Mask:   validMask(g.Board),
+			Policy: policy,
+		})
+		sides = append(sides, g.Board.Player())
+
+		pit := offset + pick(policy, ply < config.TempMoves, r)
+		if err := g.Move(pit); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, s := range samples {
+		s.Value = player.Outcome(g.Board, sides[i])
+	}
+
+	return samples, nil
+}
+
+// pick samples an index from the distribution or takes the most likely one
+func pick(policy []float64, sample bool, r *rand.Rand) int {
+	if sample {
+		p := r.Float64()
+		for i, v := range policy {
+			p -= v
+			if p < 0 && v > 0 {
+				return i
+			}
+		}
+	}
+
+	best := 0
+	for i, v := range policy {
+		if v > policy[best] {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,219 @@
+package alphazero
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Antonite/oware_rl/arena"
+	"github.com/Antonite/oware_rl/mcts"
+	"gonum.org/v1/gonum/mat"
+)
+
+type Config struct {
+	Hidden int
+	// Search settings for self-play and gating
+	Simulations int
+	C           float64
+	NoiseAlpha  float64
+	NoiseWeight float64
+	// Plies played in proportion to visit counts before playing greedily
+	TempMoves int
+	// Self-play games per iteration
+	Games int
+	// Most recent samples kept for training
+	Window       int
+	Epochs       int
+	BatchSize    int
+	LearningRate float64
+	WeightDecay  float64
+	// Games the candidate plays against the best network and the score it needs to replace it
+	GateGames     int
+	GateThreshold float64
+	Parallel      int
+	Seed          int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Hidden:        64,
+		Simulations:   100,
+		C:             1.5,
+		NoiseAlpha:    0.3,
+		NoiseWeight:   0.25,
+		TempMoves:     12,
+		Games:         50,
+		Window:        50000,
+		Epochs:        2,
+		BatchSize:     64,
+		LearningRate:  0.01,
+		WeightDecay:   1e-4,
+		GateGames:     20,
+		GateThreshold: 0.55,
+		Parallel:      4,
+		Seed:          time.Now().UnixNano(),
+	}
+}
+
+// Trainer runs the self-play, training and gating loop
+type Trainer struct {
+	config  Config
+	best    *Network
+	samples []*Sample
+	rand    *rand.Rand
+}
+
+// Iteration summarizes one round of the loop
+type Iteration struct {
+	Samples    int
+	PolicyLoss float64
+	ValueLoss  float64
+	GateScore  float64
+	Accepted   bool
+}
+
+func NewTrainer(config Config, best *Network) *Trainer {
+	r := rand.New(rand.NewSource(config.Seed))
+	if best == nil {
+		best = NewNetwork(config.Hidden, r)
+	}
+
+	return &Trainer{
+		config: config,
+		best:   best,
+		rand:   r,
+	}
+}
+
+func (t *Trainer) Best() *Network {
+	return t.best
+}
+
+func (t *Trainer) Iterate() (*Iteration, error) {
+	samples, err := t.selfPlay()
+	if err != nil {
+		return nil, err
+	}
+
+	t.samples = append(t.samples, samples...)
+	if len(t.samples) > t.config.Window {
+		t.samples = t.samples[len(t.samples)-t.config.Window:]
+	}
+
+	candidate := t.best.Clone()
+	policyLoss, valueLoss := t.fit(candidate)
+
+	result := arena.Match(
+		arena.Entrant{Name: "candidate", Player: t.gatePlayer(candidate)},
+		arena.Entrant{Name: "best", Player: t.gatePlayer(t.best)},
+		t.config.GateGames, t.config.Parallel, nil)
+
+	it := &Iteration{
+		Samples:    len(samples),
+		PolicyLoss: policyLoss,
+		ValueLoss:  valueLoss,
+		GateScore:  result.Score(),
+		Accepted:   result.Score() >= t.config.GateThreshold,
+	}
+	if it.Accepted {
+		t.best = candidate
+	}
+
+	return it, nil
+}
+
+// gatePlayer keeps the root noise so gating games don't all repeat the same line
+func (t *Trainer) gatePlayer(n *Network) *mcts.Engine {
+	config := mcts.DefaultConfig()
+	config.Simulations = t.config.Simulations
+	config.C = t.config.C
+	config.Oracle = n
+	config.NoiseAlpha = t.config.NoiseAlpha
+	config.NoiseWeight = t.config.NoiseWeight
+	return mcts.New(config)
+}
+
+// selfPlay plays the iteration's games with the best network on parallel workers
+func (t *Trainer) selfPlay() ([]*Sample, error) {
+	games := make(chan int64)
+	go func() {
+		for g := 0; g < t.config.Games; g++ {
+			games <- t.rand.Int63()
+		}
+		close(games)
+	}()
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	samples := []*Sample{}
+	var firstErr error
+	for w := 0; w < t.config.Parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seed := range games {
+				s, err := selfPlay(t.best, t.config, rand.New(rand.NewSource(seed)))
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				samples = append(samples, s...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, fmt.Errorf("self-play failed: %w", firstErr)
+	}
+
+	return samples, nil
+}
+
+// fit trains the network on shuffled minibatches of the sample window and returns the average losses
+func (t *Trainer) fit(n *Network) (float64, float64) {
+	policyLoss := 0.0
+	valueLoss := 0.0
+	batches := 0
+	for e := 0; e < t.config.Epochs; e++ {
+		order := t.rand.Perm(len(t.samples))
+		for start := 0; start < len(order); start += t.config.BatchSize {
+			end := start + t.config.BatchSize
+			if end > len(order) {
+				end = len(order)
+			}
+
+			x, mask, policy, value := batch(t.samples, order[start:end])
+			p, v := n.train(x, mask, policy, value, t.config.LearningRate, t.config.WeightDecay)
+			policyLoss += p
+			valueLoss += v
+			batches++
+		}
+	}
+
+	if batches == 0 {
+		return 0, 0
+	}
+
+	return policyLoss / float64(batches), valueLoss / float64(batches)
+}
+
+func batch(samples []*Sample, indexes []int) (*mat.Dense, *mat.Dense, *mat.Dense, *mat.Dense) {
+	rows := len(indexes)
+	x := mat.NewDense(rows, inputCount, nil)
+	mask := mat.NewDense(rows, policyCount, nil)
+	policy := mat.NewDense(rows, policyCount, nil)
+	value := mat.NewDense(rows, 1, nil)
+	for i, idx := range indexes {
+		s := samples[idx]
+		x.SetRow(i, s.Input)
+		mask.SetRow(i, s.Mask)
+		policy.SetRow(i, s.Policy)
+		value.Set(i, 0, s.Value)
+	}
+
+	return x, mask, policy, value
+}
@@ -0,0 +1,167 @@
+package arena
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/Antonite/oware_rl/player"
+)
+
+// Entrant is a named player. Players must be safe for concurrent use.
+type Entrant struct {
+	Name   string
+	Player player.Player
+}
+
+// Recorder receives every finished game. Score is A's result: 1 for a win, 0.5 for a tie and 0 for a loss.
+type Recorder interface {
+	Record(a, b string, score float64) error
+}
+
+// Result is the record of A against B
+type Result struct {
+	A      string
+	B      string
+	Wins   int
+	Losses int
+	Ties   int
+	Errors int
+	// Sum of A's score minus B's score and its square
+	Margin   float64
+	MarginSq float64
+}
+
+func (r *Result) Games() int {
+	return r.Wins + r.Losses + r.Ties
+}
+
+// Score is A's expected score per game, counting ties as half a win
+func (r *Result) Score() float64 {
+	if r.Games() == 0 {
+		return 0
+	}
+
+	return (float64(r.Wins) + float64(r.Ties)/2) / float64(r.Games())
+}
+
+// ScoreInterval is the half width of the 95% confidence interval of Score
+func (r *Result) ScoreInterval() float64 {
+	n := float64(r.Games())
+	if n < 2 {
+		return math.Inf(1)
+	}
+
+	mean := r.Score()
+	variance := (float64(r.Wins)+float64(r.Ties)/4)/n - mean*mean
+	return 1.96 * math.Sqrt(variance/(n-1))
+}
+
+func (r *Result) AverageMargin() float64 {
+	if r.Games() == 0 {
+		return 0
+	}
+
+	return r.Margin / float64(r.Games())
+}
+
+// MarginInterval is the half width of the 95% confidence interval of AverageMargin
+func (r *Result) MarginInterval() float64 {
+	n := float64(r.Games())
+	if n < 2 {
+		return math.Inf(1)
+	}
+
+	mean := r.AverageMargin()
+	variance := r.MarginSq/n - mean*mean
+	return 1.96 * math.Sqrt(math.Max(variance, 0)/(n-1))
+}
+
+// record adds a game and returns A's score for it
+func (r *Result) record(g *player.Game, aSide int) float64 {
+	scores := g.Board.Scores()
+	margin := float64(scores[aSide] - scores[(aSide+1)%2])
+	r.Margin += margin
+	r.MarginSq += margin * margin
+
+	switch player.Winner(g.Board) {
+	case aSide:
+		r.Wins++
+		return 1
+	case -1:
+		r.Ties++
+		return 0.5
+	default:
+		r.Losses++
+		return 0
+	}
+}
+
+// Match plays games between a and b, alternating who moves first. Recorder may be nil.
+func Match(a, b Entrant, games int, parallel int, recorder Recorder) *Result {
+	return RoundRobin([]Entrant{a, b}, games, parallel, recorder)[0]
+}
+
+// RoundRobin plays games between every pair of entrants, alternating who moves first.
+// All games share one pool of parallel workers. Recorder may be nil.
+func RoundRobin(entrants []Entrant, games int, parallel int, recorder Recorder) []*Result {
+	type job struct {
+		result *Result
+		a, b   Entrant
+		aSide  int
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := []*Result{}
+	jobs := make(chan job)
+	go func() {
+		for i := 0; i < len(entrants); i++ {
+			for j := i + 1; j < len(entrants); j++ {
+				r := &Result{A: entrants[i].Name, B: entrants[j].Name}
+				results = append(results, r)
+				for g := 0; g < games; g++ {
+					jobs <- job{result: r, a: entrants[i], b: entrants[j], aSide: g % 2}
+				}
+			}
+		}
+		close(jobs)
+	}()
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				players := [2]player.Player{}
+				players[j.aSide] = j.a.Player
+				players[(j.aSide+1)%2] = j.b.Player
+				g, err := player.Play(players)
+
+				mu.Lock()
+				if err != nil {
+					j.result.Errors++
+					mu.Unlock()
+					fmt.Printf("game between %s and %s failed. %v\n", j.a.Name, j.b.Name, err)
+					continue
+				}
+				score := j.result.record(g, j.aSide)
+				mu.Unlock()
+
+				if recorder == nil {
+					continue
+				}
+				if err := recorder.Record(j.a.Name, j.b.Name, score); err != nil {
+					fmt.Printf("failed to record game. %v\n", err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/Antonite/oware_rl/alphazero"
+)
+
+func main() {
+	config := alphazero.DefaultConfig()
+	var iterations = flag.Int("iterations", 100, "self-play, training and gating rounds")
+	var in = flag.String("in", "", "network to resume from")
+	var out = flag.String("out", "alphazero.gob", "file the best network is saved to")
+	flag.IntVar(&config.Hidden, "hidden", config.Hidden, "hidden units per layer")
+	flag.IntVar(&config.Simulations, "simulations", config.Simulations, "searches per move")
+	flag.IntVar(&config.Games, "games", config.Games, "self-play games per iteration")
+	flag.IntVar(&config.Window, "window", config.Window, "most recent samples trained on")
+	flag.IntVar(&config.Epochs, "epochs", config.Epochs, "passes over the window per iteration")
+	flag.IntVar(&config.BatchSize, "batch", config.BatchSize, "minibatch size")
+	flag.Float64Var(&config.LearningRate, "lr", config.LearningRate, "learning rate")
+	flag.Float64Var(&config.WeightDecay, "weight-decay", config.WeightDecay, "L2 weight decay")
+	flag.IntVar(&config.GateGames, "gate-games", config.GateGames, "games between candidate and best")
+	flag.Float64Var(&config.GateThreshold, "gate-threshold", config.GateThreshold, "score the candidate needs to become best")
+	flag.IntVar(&config.Parallel, "parallel", runtime.NumCPU(), "games played at once")
+	flag.Parse()
+
+	fmt.Println("starting oware alphazero...")
+
+	var best *alphazero.Network
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			panic(err)
+		}
+		best, err = alphazero.Load(f)
+		f.Close()
+		if err != nil {
+			fmt.Printf("failed to load network. %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	t := alphazero.NewTrainer(config, best)
+	for i := 1; i <= *iterations; i++ {
+		it, err := t.Iterate()
+		if err != nil {
+			fmt.Printf("iteration %v failed. %v\n", i, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("iteration %v: samples %v policy loss %.4f value loss %.4f gate score %.3f accepted %v\n",
+			i, it.Samples, it.PolicyLoss, it.ValueLoss, it.GateScore, it.Accepted)
+
+		if it.Accepted {
+			if err := save(t.Best(), *out); err != nil {
+				fmt.Printf("failed to save network. %v\n", err)
+			}
+		}
+	}
+}
+
+func save(n *alphazero.Network, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := n.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/Antonite/oware_rl/agents"
+	"github.com/Antonite/oware_rl/arena"
+	"github.com/Antonite/oware_rl/rating"
+)
+
+func main() {
+	var specs = flag.String("agents", "qtable,random", "comma separated agents, optionally labelled label=agent to rate them by label: "+agents.Usage)
+	var games = flag.Int("games", 100, "games per pairing")
+	var parallel = flag.Int("parallel", runtime.NumCPU(), "games played at once")
+	var backend = flag.String("store", "couchbase", "[couchbase,memory,disk]")
+	var path = flag.String("path", "qtable.db", "file for the disk store")
+	var network = flag.String("network", "", "qdeepneuro checkpoint for agents that don't name one")
+	var ledgerPath = flag.String("ledger", "", "rating ledger to record games in")
+	flag.Parse()
+
+	env := &agents.Env{Backend: *backend, Path: *path, Network: *network}
+	defer env.Close()
+
+	entrants := []arena.Entrant{}
+	labels := make(map[string]bool)
+	for _, spec := range strings.Split(*specs, ",") {
+		// Labels tell apart checkpoints and snapshots in the ledger, agents are named by spec otherwise
+		label := spec
+		if i := strings.Index(spec, "="); i >= 0 {
+			label, spec = spec[:i], spec[i+1:]
+		}
+		if label == "" || labels[label] {
+			fmt.Printf("agent labels must be unique and not empty: %s\n", label)
+			os.Exit(2)
+		}
+		labels[label] = true
+
+		p, err := agents.New(spec, env)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		entrants = append(entrants, arena.Entrant{Name: label, Player: p})
+	}
+
+	if len(entrants) < 2 {
+		fmt.Println("need at least two agents")
+		os.Exit(2)
+	}
+
+	var recorder arena.Recorder
+	if *ledgerPath != "" {
+		ledger, err := rating.Open(*ledgerPath)
+		if err != nil {
+			fmt.Printf("failed to open ledger. %v\n", err)
+			os.Exit(1)
+		}
+		defer ledger.Close()
+		recorder = ledger
+	}
+
+	fmt.Printf("playing %v games per pairing between %v agents...\n", *games, len(entrants))
+	results := arena.RoundRobin(entrants, *games, *parallel, recorder)
+	for _, r := range results {
+		fmt.Printf("%s vs %s: %v-%v-%v score %.3f ± %.3f margin %.2f ± %.2f",
+			r.A, r.B, r.Wins, r.Losses, r.Ties, r.Score(), r.ScoreInterval(), r.AverageMargin(), r.MarginInterval())
+		if r.Errors > 0 {
+			fmt.Printf(" errors %v", r.Errors)
+		}
+		fmt.Println()
+	}
+
+	if len(entrants) > 2 {
+		printStandings(results)
+	}
+}
+
+func printStandings(results []*arena.Result) {
+	points := make(map[string]float64)
+	games := make(map[string]int)
+	for _, r := range results {
+		a := float64(r.Wins) + float64(r.Ties)/2
+		points[r.A] += a
+		points[r.B] += float64(r.Games()) - a
+		games[r.A] += r.Games()
+		games[r.B] += r.Games()
+	}
+
+	names := []string{}
+	for n := range points {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool {
+		return points[names[i]] > points[names[j]]
+	})
+
+	fmt.Println("standings:")
+	for i, n := range names {
+		fmt.Printf("%v. %s %.1f/%v\n", i+1, n, points[n], games[n])
+	}
+}
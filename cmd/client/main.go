@@ -1,154 +1,102 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 
-	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/agents"
+	"github.com/Antonite/oware_rl/player"
 	"github.com/Antonite/oware_rl/qtable"
 	"github.com/Antonite/oware_rl/storage"
 )
 
 func main() {
-	var player = flag.Int("player", 0, "[0,1]")
+	var side = flag.Int("player", 0, "[0,1]")
+	var backend = flag.String("store", "couchbase", "[couchbase,memory,disk]")
+	var path = flag.String("path", "qtable.db", "file for the disk store")
+	var opponent = flag.String("opponent", "qtable", "agent to play against: "+agents.Usage)
+	var network = flag.String("network", "", "qdeepneuro checkpoint for agents that don't name one")
 	flag.Parse()
-	if *player != 0 && *player != 1 {
+	if *side != 0 && *side != 1 {
 		flag.Usage()
 		return
 	}
 
-	fmt.Printf("starting oware client for player: %v\n", *player)
+	fmt.Printf("starting oware client for player: %v\n", *side)
 
-	store, err := storage.Init(50)
+	store, err := storage.Open(*backend, *path, 50)
 	if err != nil {
 		fmt.Println("failed to initialize storage")
 		panic(err)
 	}
 
-	a := qtable.New(store)
-	for a.Board().Status == oware.InProgress {
-		sroot := a.Board().ToString()
-		moves := a.Board().GetValidMoves()
+	env := agents.NewEnv(store)
+	env.Network = *network
+	ai, err := agents.New(*opponent, env)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
 
-		// Get possible moves with reward values
-		moveMap := a.ExploreCurrentMoves(moves, sroot)
-		pitMap := make(map[string]int)
+	players := [2]player.Player{}
+	players[*side] = player.NewHuman(os.Stdin, os.Stdout)
+	players[(*side+1)%2] = ai
 
+	// The agent records the game so the table can learn from it
+	a := qtable.New(store, qtable.DefaultConfig())
+	g := player.NewGame(a.Board())
+	for !g.Over() {
 		fmt.Println("-------------------------------------------")
 		fmt.Println("-------------------------------------------")
-		fmt.Printf("Board state: %v\n", a.Board())
-		fmt.Println("Move options:")
-		for _, m := range moves {
-			nb, err := a.Board().Move(m)
-			if err != nil {
-				continue
-			}
-
-			nbs := nb.ToString()
-
-			reward, ok := moveMap[nbs]
-			if !ok {
-				fmt.Printf("couldn't find move in storage: %s, for key: %s\n", nbs, sroot)
-				return
-			}
+		fmt.Printf("Board state: %v\n", g.Board)
 
-			pitMap[nbs] = m
+		// Make sure the reachable positions are stored so the game can be rewarded
+		a.SetBoard(g.Board)
+		a.ExploreCurrentMoves(g.Board.GetValidMoves(), g.Board.ToString())
 
-			fmt.Printf("Pit: %v Outcome: %s Reward: %v\n", m, nbs, reward)
+		current := players[g.Board.Player()]
+		pit, scores, err := current.Choose(g.Board)
+		if err != nil {
+			fmt.Printf("%s failed to choose a move\n", current.Name())
+			panic(err)
 		}
 
-		// Has this moved been played yet?
-		played := false
-		bestMove := ""
-
-		if a.Board().Player() == *player {
-			// Player's turn
-			fmt.Println("Your turn. Waiting for move selection...")
-
-			// wait for input
-			input := bufio.NewScanner(os.Stdin)
-			waitforinput := true
-			for waitforinput {
-				input.Scan()
-				playerMove, err := strconv.Atoi(input.Text())
-				if err != nil {
-					fmt.Println("bad input, try again")
-					continue
-				}
-
-				nb, err := a.Board().Move(playerMove)
-				if err != nil {
-					fmt.Println("bad input, try again")
-					continue
-				}
-
-				bestMove = nb.ToString()
-				waitforinput = false
-				fmt.Printf("You chose pit: %v\n", playerMove)
-			}
-
-			// Ensure this move hasn't been played yet
-			played = a.MovePlayed(bestMove)
-		} else {
-			// AI's turn
-			// Decide on best move
-			fmt.Println("AI's turn. Waiting for move selection...")
-			bestValue := 0
-			for k, v := range moveMap {
-				// Ensure this move hasn't been played yet
-				played = a.MovePlayed(k)
-				if !played && (bestMove == "" || v > bestValue) {
-					bestMove = k
-					bestValue = v
-				}
-			}
-
-			if bestMove == "" {
-				played = true
+		if scores != nil {
+			fmt.Println("Move options:")
+			for _, m := range g.Board.GetValidMoves() {
+				fmt.Printf("Pit: %v Score: %v\n", m, scores[m])
 			}
+		}
+		fmt.Printf("%s chose: %v\n", current.Name(), pit)
 
-			pit, ok := pitMap[bestMove]
-			if !ok {
-				fmt.Printf("AI chose: end game")
-			} else {
-				fmt.Printf("AI chose: %v\n", pit)
-			}
+		if err := g.Move(pit); err != nil {
+			fmt.Printf("failed to make move: %v\n", pit)
+			panic(err)
 		}
 
-		// Repeating, must end game
-		if played {
-			a.Board().ForceEndGame()
-			fmt.Printf("forcefully ended game due to repetition: %s\n", sroot)
+		// Repeating, game was ended
+		if g.Repeated {
+			fmt.Println("forcefully ended game due to repetition")
 			continue
 		}
 
 		// Record for reward distribution
-		a.RecordMove(bestMove)
-
-		// Convert the move
-		nb, err := oware.NewS(bestMove)
-		if err != nil {
-			fmt.Printf("failed to convert board: %s\n", bestMove)
-			panic(err)
-		}
-
-		a.SetBoard(nb)
+		a.RecordMove(g.Board.ToString())
 	}
 
 	fmt.Println("-------------------------------------------")
 	fmt.Println("-------------------------------------------")
 	fmt.Println("-------------------------------------------")
 	fmt.Println("Game ended. Distributing awards")
-	fmt.Println(a.Board())
+	fmt.Println(g.Board)
 
+	a.SetBoard(g.Board)
 	a.DistributeAwards()
 
-	termChan := make(chan os.Signal)
+	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
 	<-termChan
 	store.Close()
@@ -1,17 +1,264 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/Antonite/oware_rl/qdeepneuro"
+	"github.com/Antonite/oware_rl/storage"
 )
 
 func main() {
+	var encoding = flag.String("encoding", "raw", "comma separated input features [perspective,scaled,onehot,thermometer,derived]")
+	var layers = flag.String("layers", "11:relu", "hidden layers as size:activation, comma separated [relu,leakyrelu,tanh,sigmoid,linear]")
+	var output = flag.String("output", "linear", "output activation [linear,tanh,sigmoid]")
+	var method = flag.String("optimizer", "sgd", "[sgd,rmsprop,adam]")
+	var schedule = flag.String("schedule", "constant", "learning rate schedule [constant,step,exponential,cosine]")
+	var games = flag.Int("games", 1000, "self-play games to learn from")
+	var replayPath = flag.String("replay", "", "file the replay memory is loaded from and saved to")
+	var checkpoint = flag.String("checkpoint", "qdeepneuro.net", "checkpoint resumed from when it exists and saved to")
+	var every = flag.Duration("checkpoint-every", 5*time.Minute, "time between checkpoints")
+	var algorithm = flag.String("algorithm", "dqn", "[dqn,actorcritic]")
+	var distill = flag.Bool("distill", false, "fit the network to a stored qtable instead of self-play")
+	var backend = flag.String("store", "couchbase", "qtable store to distill [couchbase,memory,disk]")
+	var path = flag.String("path", "qtable.db", "file for the disk store")
+	var distillTarget = flag.String("target", "average", "distilled value [average,raw]: average fits (Reward - seed)/Games for broadcast tables, raw fits Reward")
+	config := qdeepneuro.DefaultConfig()
+	o := &config.Optimizer
+	flag.Float64Var(&o.LearningRate, "lr", o.LearningRate, "learning rate")
+	flag.Float64Var(&o.Momentum, "momentum", o.Momentum, "momentum for sgd")
+	flag.Float64Var(&o.WeightDecay, "weight-decay", o.WeightDecay, "L2 weight decay")
+	flag.Float64Var(&o.ClipNorm, "clip", o.ClipNorm, "maximum global gradient norm, 0 to disable")
+	flag.IntVar(&o.DecaySteps, "decay-steps", o.DecaySteps, "updates per learning rate decay")
+	flag.Float64Var(&o.DecayRate, "decay-rate", o.DecayRate, "learning rate multiplier per decay")
+	flag.IntVar(&config.ReplayCapacity, "replay-size", config.ReplayCapacity, "transitions kept for replay")
+	flag.IntVar(&config.BatchSize, "batch", config.BatchSize, "transitions per update")
+	flag.BoolVar(&config.Prioritized, "prioritized", config.Prioritized, "sample transitions by their last error")
+	flag.Float64Var(&config.Gamma, "gamma", config.Gamma, "discount on the opponent's best reply")
+	flag.IntVar(&config.TargetSync, "target-sync", config.TargetSync, "steps between target network copies")
+	flag.Float64Var(&config.Tau, "tau", config.Tau, "Polyak averaging rate for the target network, replaces -target-sync when above 0")
+	flag.BoolVar(&config.Double, "double", config.Double, "use the Double DQN target")
+	flag.Float64Var(&config.Epsilon, "epsilon", config.Epsilon, "chance of a random self-play move")
+	flag.Float64Var(&config.SeedReward, "seed-reward", config.SeedReward, "reward per captured seed")
+	dc := qdeepneuro.DefaultDistillConfig()
+	flag.IntVar(&dc.Epochs, "epochs", dc.Epochs, "distillation passes over the table")
+	flag.Float64Var(&dc.Validation, "validation", dc.Validation, "share of positions held out from distillation")
+	flag.BoolVar(&dc.LogVisits, "log-visits", dc.LogVisits, "weight distilled positions by log visits instead of visits")
+	flag.Float64Var(&config.Entropy, "entropy", config.Entropy, "entropy bonus for actorcritic")
+	flag.BoolVar(&config.Bootstrap, "bootstrap", config.Bootstrap, "actorcritic advantages from the value network instead of episode returns")
+	flag.IntVar(&config.Workers, "workers", runtime.NumCPU(), "self-play goroutines")
+	flag.IntVar(&config.PublishEvery, "publish-every", config.PublishEvery, "training steps between networks published to the workers")
+	flag.Float64Var(&o.MinLearningRate, "min-lr", o.MinLearningRate, "lowest scheduled learning rate")
+	flag.Parse()
+
+	var err error
+	config.Encoding, err = qdeepneuro.ParseEncoding(*encoding)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	hidden, err := qdeepneuro.ParseLayers(*layers)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	config.Hidden = hidden
+	config.Output, err = qdeepneuro.ParseActivation(*output)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	o.Method, err = qdeepneuro.ParseMethod(*method)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	o.Schedule, err = qdeepneuro.ParseSchedule(*schedule)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
 	fmt.Println("starting oware deep q RL...")
 
-	l := qdeepneuro.NewLeaner()
-	l.Learn()
+	switch *algorithm {
+	case "dqn":
+	case "actorcritic":
+		actorCritic(config, *checkpoint, *every, *games)
+		return
+	default:
+		fmt.Printf("unknown algorithm: %s\n", *algorithm)
+		os.Exit(2)
+	}
+
+	var replay *qdeepneuro.Replay
+	if *replayPath != "" {
+		if f, err := os.Open(*replayPath); err == nil {
+			replay, err = qdeepneuro.LoadReplay(f, config)
+			f.Close()
+			if err != nil {
+				fmt.Printf("failed to load replay memory. %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("loaded %v transitions\n", replay.Len())
+		}
+	}
+
+	var l *qdeepneuro.Learner
+	if _, err := os.Stat(*checkpoint); err == nil {
+		l, err = qdeepneuro.LoadLearner(*checkpoint, config, replay)
+		if err != nil {
+			fmt.Printf("failed to load checkpoint. %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("resumed from %v at step %v\n", *checkpoint, l.Steps())
+	} else {
+		l = qdeepneuro.NewLeaner(config, replay)
+	}
+
+	fmt.Printf("training %v\n", l.Config())
+
+	go func() {
+		for range time.Tick(*every) {
+			if err := l.SaveFile(*checkpoint); err != nil {
+				fmt.Printf("failed to save checkpoint. %v\n", err)
+			}
+		}
+	}()
+
+	if *distill {
+		if *distillTarget != "average" && *distillTarget != "raw" {
+			fmt.Printf("unknown target: %s\n", *distillTarget)
+			os.Exit(2)
+		}
+		dc.Average = *distillTarget == "average"
+		dc.BatchSize = config.BatchSize
+
+		store, err := storage.Open(*backend, *path, 1)
+		if err != nil {
+			fmt.Println("failed to initialize storage")
+			panic(err)
+		}
+
+		err = l.Distill(store, dc, func(e qdeepneuro.EpochLoss) {
+			fmt.Printf("epoch %v: train loss %.5f (%v positions) validation loss %.5f (%v positions)\n",
+				e.Epoch, e.Train, e.TrainPositions, e.Validation, e.ValidationPositions)
+		})
+		store.Close()
+		if err != nil {
+			fmt.Printf("distillation failed. %v\n", err)
+			os.Exit(1)
+		}
+
+		shutdown(l, *checkpoint, *replayPath)
+		return
+	}
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-termChan
+		shutdown(l, *checkpoint, *replayPath)
+		os.Exit(0)
+	}()
+
+	if err := l.Learn(*games); err != nil {
+		fmt.Printf("self-play failed. %v\n", err)
+	}
+	fmt.Printf("played %v games, trained on %v batches\n", *games, l.Steps())
+
+	shutdown(l, *checkpoint, *replayPath)
+}
+
+// actorCritic trains a policy and value network from self-play episodes
+func actorCritic(config qdeepneuro.Config, checkpoint string, every time.Duration, episodes int) {
+	var a *qdeepneuro.ActorCritic
+	if _, err := os.Stat(checkpoint); err == nil {
+		a, err = qdeepneuro.LoadActorCritic(checkpoint, config)
+		if err != nil {
+			fmt.Printf("failed to load checkpoint. %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("resumed from %v at episode %v\n", checkpoint, a.Episodes())
+	} else {
+		a = qdeepneuro.NewActorCritic(config)
+	}
+
+	fmt.Printf("training actor-critic %v\n", a.Config())
+
+	save := func() {
+		if err := a.SaveFile(checkpoint); err != nil {
+			fmt.Printf("failed to save checkpoint. %v\n", err)
+		}
+	}
+
+	go func() {
+		for range time.Tick(every) {
+			save()
+		}
+	}()
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-termChan
+		save()
+		os.Exit(0)
+	}()
+
+	policyLoss, valueLoss, entropy := 0.0, 0.0, 0.0
+	err := a.Train(episodes, func(s qdeepneuro.EpisodeStats) {
+		policyLoss += s.PolicyLoss
+		valueLoss += s.ValueLoss
+		entropy += s.Entropy
+		if s.Episode%100 == 0 {
+			fmt.Printf("episode %v: policy loss %.4f value loss %.4f entropy %.4f\n",
+				s.Episode, policyLoss/100, valueLoss/100, entropy/100)
+			policyLoss, valueLoss, entropy = 0, 0, 0
+		}
+	})
+	if err != nil {
+		fmt.Printf("self-play failed. %v\n", err)
+	}
+
+	save()
+}
+
+// shutdown saves the checkpoint and the replay memory
+func shutdown(l *qdeepneuro.Learner, checkpoint string, replayPath string) {
+	if err := l.SaveFile(checkpoint); err != nil {
+		fmt.Printf("failed to save checkpoint. %v\n", err)
+	}
+
+	if replayPath != "" {
+		if err := saveReplay(l.Replay(), replayPath); err != nil {
+			fmt.Printf("failed to save replay memory. %v\n", err)
+		}
+	}
+}
+
+func saveReplay(r *qdeepneuro.Replay, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
 
-	time.Sleep(time.Minute)
+	return os.Rename(tmp, path)
 }
@@ -1,18 +1,83 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/Antonite/oware_rl/qtable"
+	"github.com/Antonite/oware_rl/snapshot"
 	"github.com/Antonite/oware_rl/storage"
 )
 
+const usage = "usage: qtable [train|export|import|migrate] [flags]"
+
 func main() {
+	command := "train"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0][0] != '-' {
+		command = args[0]
+		args = args[1:]
+	}
+
+	switch command {
+	case "train":
+		train(args)
+	case "export":
+		export(args)
+	case "import":
+		load(args)
+	case "migrate":
+		migrate(args)
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+func train(args []string) {
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	backend, path := storeFlags(fs)
+	config := qtable.DefaultConfig()
+	mode := fs.String("mode", "broadcast", "[broadcast,qlearning,tdlambda]")
+	fs.Float64Var(&config.Alpha, "alpha", config.Alpha, "learning rate for qlearning and tdlambda")
+	fs.Float64Var(&config.Gamma, "gamma", config.Gamma, "discount for qlearning and tdlambda")
+	fs.Float64Var(&config.Lambda, "lambda", config.Lambda, "trace decay for tdlambda")
+	params := qtable.DefaultExplorerParams()
+	explore := fs.String("explore", "greedy", "[greedy,epsilon,boltzmann,ucb1]: ucb1 only with qlearning and tdlambda")
+	fs.Float64Var(&params.Epsilon, "epsilon", params.Epsilon, "starting exploration rate for epsilon")
+	fs.Float64Var(&params.EpsilonDecay, "epsilon-decay", params.EpsilonDecay, "epsilon decay per game")
+	fs.Float64Var(&params.EpsilonMin, "epsilon-min", params.EpsilonMin, "lowest epsilon")
+	fs.Float64Var(&params.Temperature, "temperature", params.Temperature, "temperature for boltzmann")
+	fs.Float64Var(&params.C, "ucb-c", params.C, "exploration constant for ucb1")
+	fs.Parse(args)
+
+	var err error
+	config.Mode, err = qtable.ParseMode(*mode)
+	if err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config.Explorer, err = qtable.ParseExplorer(*explore, params)
+	if err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if _, ok := config.Explorer.(*qtable.UCB1); ok && config.Mode == qtable.Broadcast {
+		fmt.Println("ucb1 needs mean rewards, use it with qlearning or tdlambda")
+		fs.Usage()
+		os.Exit(2)
+	}
+
 	fmt.Println("starting oware RL...")
 
-	store, err := storage.Init(1000)
+	store, err := storage.Open(*backend, *path, 1000)
 	if err != nil {
 		fmt.Println("failed to initialize storage")
 		panic(err)
@@ -22,8 +87,156 @@ func main() {
 	for w := 1; w <= 1000; w++ {
 		wg.Add(1)
 		time.Sleep(time.Millisecond * 200)
-		go qtable.PlayForever(store, w)
+		go qtable.PlayForever(store, config, w)
 	}
 
 	wg.Wait()
 }
+
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	backend, path := storeFlags(fs)
+	out := fs.String("out", "qtable.snap", "snapshot file to write")
+	fs.Parse(args)
+
+	store, err := storage.Open(*backend, *path, 0)
+	if err != nil {
+		fmt.Println("failed to initialize storage")
+		panic(err)
+	}
+	defer store.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		panic(err)
+	}
+
+	count, err := snapshot.Export(store, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Printf("failed to export after %v states. %v\n", count, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("exported %v states to %s\n", count, *out)
+}
+
+func load(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	backend, path := storeFlags(fs)
+	in := fs.String("in", "qtable.snap", "snapshot file to read")
+	mode := fs.String("mode", "broadcast", "how the table was trained [broadcast,qlearning,tdlambda], decides how mirrored rewards merge")
+	fs.Parse(args)
+
+	m, err := qtable.ParseMode(*mode)
+	if err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store, err := storage.Open(*backend, *path, 0)
+	if err != nil {
+		fmt.Println("failed to initialize storage")
+		panic(err)
+	}
+	defer store.Close()
+
+	f, err := os.Open(*in)
+	if err != nil {
+		fmt.Println(err)
+		store.Close()
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	// Snapshots taken before keys were canonical hold both orientations of positions, they merge
+	inserted, merged, err := snapshot.Import(store, f, qtable.MergeReward(m))
+	if err != nil {
+		fmt.Printf("failed to import after %v states. %v\n", inserted+merged, err)
+		store.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("imported %v new states, merged %v into existing states from %s\n", inserted, merged, *in)
+}
+
+// migrate moves a store written before keys were canonical to canonical keys. Couchbase buckets
+// are folded in place, other stores are copied into a new canonical store.
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	backend, path := storeFlags(fs)
+	toBackend := fs.String("to-store", "", "canonical store to copy to [couchbase,memory,disk], empty to migrate couchbase in place")
+	toPath := fs.String("to-path", "qtable.canonical.db", "file for the disk store to copy to")
+	mode := fs.String("mode", "broadcast", "how the table was trained [broadcast,qlearning,tdlambda], decides how mirrored rewards merge")
+	fs.Parse(args)
+
+	m, err := qtable.ParseMode(*mode)
+	if err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	merge := qtable.MergeReward(m)
+
+	if *toBackend == "" {
+		if *backend != "couchbase" {
+			fmt.Println("only couchbase migrates in place, set -to-store")
+			os.Exit(2)
+		}
+
+		store, err := storage.InitCouchbase(0)
+		if err != nil {
+			fmt.Println("failed to initialize storage")
+			panic(err)
+		}
+		defer store.Close()
+
+		count, err := store.Fold(merge)
+		if err != nil {
+			fmt.Printf("failed to fold after %v states, run migrate again to continue. %v\n", count, err)
+			store.Close()
+			os.Exit(1)
+		}
+
+		fmt.Printf("folded %v states into their canonical keys\n", count)
+		return
+	}
+
+	if *backend == *toBackend && (*backend != "disk" || *path == *toPath) {
+		fmt.Println("migrate needs a different store to copy to")
+		os.Exit(2)
+	}
+
+	from, err := storage.OpenRaw(*backend, *path, 0)
+	if err != nil {
+		fmt.Println("failed to initialize storage")
+		panic(err)
+	}
+	defer from.Close()
+
+	to, err := storage.Open(*toBackend, *toPath, 0)
+	if err != nil {
+		fmt.Println("failed to initialize storage")
+		panic(err)
+	}
+	defer to.Close()
+
+	inserted, merged, err := storage.Migrate(from, to, merge)
+	if err != nil {
+		fmt.Printf("failed to migrate after %v states. %v\n", inserted+merged, err)
+		from.Close()
+		to.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("migrated %v new canonical states, merged %v into existing states\n", inserted, merged)
+}
+
+func storeFlags(fs *flag.FlagSet) (*string, *string) {
+	backend := fs.String("store", "couchbase", "[couchbase,memory,disk]")
+	path := fs.String("path", "qtable.db", "file for the disk store")
+	return backend, path
+}
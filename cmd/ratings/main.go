@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Antonite/oware_rl/rating"
+)
+
+func main() {
+	var ledger = flag.String("ledger", "ratings.jsonl", "rating ledger to read")
+	var agent = flag.String("agent", "", "only show this agent")
+	flag.Parse()
+
+	ratings, err := rating.Read(*ledger)
+	if err != nil {
+		fmt.Printf("failed to read ledger. %v\n", err)
+		os.Exit(1)
+	}
+
+	for i, r := range ratings {
+		if *agent != "" && r.Name != *agent {
+			continue
+		}
+
+		fmt.Printf("%v. %s elo %.0f games %v (%v-%v-%v)\n", i+1, r.Name, r.Elo, r.Games, r.Wins, r.Losses, r.Ties)
+	}
+}
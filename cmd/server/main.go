@@ -1,16 +1,39 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/Antonite/oware_rl/agents"
 	"github.com/Antonite/oware_rl/server"
+	"github.com/Antonite/oware_rl/storage"
 )
 
 func main() {
-	server := server.New()
+	var backend = flag.String("store", "couchbase", "[couchbase,memory,disk]")
+	var path = flag.String("path", "qtable.db", "file for the disk store")
+	var ledger = flag.String("ledger", "ratings.jsonl", "rating ledger served on /ratings")
+	var network = flag.String("network", "", "qdeepneuro checkpoint for agents that don't name one")
+	var specs = flag.String("agents", "qtable,random,minimax:4", "comma separated agents served on /moves: "+agents.Usage)
+	flag.Parse()
+
+	store, err := storage.Open(*backend, *path, 50)
+	if err != nil {
+		fmt.Println("failed to initialize storage")
+		panic(err)
+	}
+
+	server, err := server.New(store, *ledger, *network, strings.Split(*specs, ","))
+	if err != nil {
+		fmt.Println(err)
+		store.Close()
+		os.Exit(2)
+	}
 
 	http.HandleFunc("/moves", func(w http.ResponseWriter, r *http.Request) {
 		server.GetMovesHandler(w, r)
@@ -20,6 +43,10 @@ func main() {
 		server.GetBoardHandler(w, r)
 	})
 
+	http.HandleFunc("/ratings", func(w http.ResponseWriter, r *http.Request) {
+		server.GetRatingsHandler(w, r)
+	})
+
 	fmt.Println("Server started   " + time.Now().Format("Mon Jan _2 15:04:05 2006"))
 	log.Fatal(http.ListenAndServe(":8081", nil))
 }
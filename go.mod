@@ -3,11 +3,14 @@ module github.com/Antonite/oware_rl
 go 1.17
 
 require (
-	github.com/Antonite/oware v0.0.0-20220124013207-f141d9fe6b23 // indirect
-	github.com/couchbase/gocb/v2 v2.3.5 // indirect
+	github.com/Antonite/oware v0.0.0-20220124013207-f141d9fe6b23
+	github.com/couchbase/gocb/v2 v2.3.5
+	gonum.org/v1/gonum v0.9.3
+)
+
+require (
 	github.com/couchbase/gocbcore/v10 v10.0.6 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	gonum.org/v1/gonum v0.9.3 // indirect
 )
@@ -0,0 +1,409 @@
+package mcts
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/player"
+)
+
+// Rollouts longer than this are scored by captured seeds
+const maxRollout = 400
+
+// Prior gives the probability of each valid pit for the player to move
+type Prior interface {
+	Prior(b *oware.Board) map[int]float64
+}
+
+// Evaluator scores a board for the player to move
+type Evaluator interface {
+	Evaluate(b *oware.Board) float64
+}
+
+// Oracle gives priors and a value in [-1, 1] for the player to move from one evaluation,
+// like a policy-value network
+type Oracle interface {
+	PriorValue(b *oware.Board) (map[int]float64, float64)
+}
+
+type Config struct {
+	// Simulations per move
+	Simulations int
+	// Stop early once the time runs out, unlimited when zero
+	TimeLimit time.Duration
+	// Exploration constant
+	C float64
+	// Selection uses PUCT with these priors when set and UCT otherwise
+	Prior Prior
+	// Leaf values come from the evaluator when set and from rollouts otherwise
+	Evaluator Evaluator
+	// Evaluations are squashed with tanh(value / ValueScale) into [-1, 1]
+	ValueScale float64
+	// Rollout policy, uniformly random when nil
+	Rollout player.Player
+	// Replaces Prior and Evaluator when set
+	Oracle Oracle
+	// Dirichlet noise mixed into the root priors to diversify self-play, off when NoiseWeight is zero
+	NoiseAlpha  float64
+	NoiseWeight float64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Simulations: 1000,
+		C:           math.Sqrt2,
+		ValueScale:  1,
+	}
+}
+
+// Engine is a Monte Carlo tree search. It is safe for concurrent use, every search builds its own tree.
+type Engine struct {
+	config Config
+}
+
+func New(config Config) *Engine {
+	if config.Simulations < 1 {
+		config.Simulations = 1
+	}
+	if config.ValueScale <= 0 {
+		config.ValueScale = 1
+	}
+
+	return &Engine{config: config}
+}
+
+func (e *Engine) Name() string {
+	return fmt.Sprintf("mcts:%v", e.config.Simulations)
+}
+
+// Choose plays the most visited pit. Scores are the share of visits of every valid pit.
+func (e *Engine) Choose(b *oware.Board) (int, map[int]float64, error) {
+	scores, err := e.Policy(b)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	best := -1
+	for _, m := range b.GetValidMoves() {
+		if best == -1 || scores[m] > scores[best] {
+			best = m
+		}
+	}
+
+	return best, scores, nil
+}
+
+// Search runs the simulations from b and returns the visit count of every valid pit
+func (e *Engine) Search(b *oware.Board) (map[int]int, error) {
+	root, err := e.search(b)
+	if err != nil {
+		return nil, err
+	}
+
+	visits := make(map[int]int, len(root.children))
+	for _, c := range root.children {
+		visits[c.move] = c.visits
+	}
+
+	return visits, nil
+}
+
+// Policy runs the simulations from b and returns the share of visits of every valid pit.
+// When no pit was visited, a single simulation only expands the root, it returns the root priors.
+func (e *Engine) Policy(b *oware.Board) (map[int]float64, error) {
+	root, err := e.search(b)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, c := range root.children {
+		total += c.visits
+	}
+
+	policy := make(map[int]float64, len(root.children))
+	for _, c := range root.children {
+		if total == 0 {
+			policy[c.move] = c.prior
+		} else {
+			policy[c.move] = float64(c.visits) / float64(total)
+		}
+	}
+
+	return policy, nil
+}
+
+func (e *Engine) search(b *oware.Board) (*node, error) {
+	if len(b.GetValidMoves()) == 0 {
+		return nil, errors.New("no valid moves found")
+	}
+
+	s := &search{
+		config: e.config,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	root := &node{board: b}
+	deadline := time.Now().Add(e.config.TimeLimit)
+	for i := 0; i < e.config.Simulations; i++ {
+		// Always run at least one simulation so the root is expanded
+		if i > 0 && e.config.TimeLimit > 0 && time.Now().After(deadline) {
+			break
+		}
+
+		if err := s.simulate(root); err != nil {
+			return nil, err
+		}
+	}
+
+	return root, nil
+}
+
+type node struct {
+	board    *oware.Board
+	move     int
+	prior    float64
+	children []*node
+	expanded bool
+	visits   int
+	// Sum of values from the perspective of the player who moved into this node
+	total float64
+}
+
+type search struct {
+	config Config
+	rand   *rand.Rand
+}
+
+func (s *search) simulate(root *node) error {
+	path := []*node{root}
+	n := root
+	for n.expanded && n.board.Status == oware.InProgress {
+		n = s.selectChild(n)
+		path = append(path, n)
+	}
+
+	// Value for the player to move at the leaf
+	var v float64
+	if n.board.Status != oware.InProgress {
+		v = player.Outcome(n.board, n.board.Player())
+	} else {
+		var err error
+		v, err = s.expand(n, n == root)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Every node stores the value for the player who moved into it
+	for i := len(path) - 1; i >= 0; i-- {
+		path[i].visits++
+		path[i].total -= v
+		v = -v
+	}
+
+	return nil
+}
+
+func (s *search) selectChild(n *node) *node {
+	var best *node
+	bestScore := math.Inf(-1)
+	for _, c := range n.children {
+		score := s.score(n, c)
+		if score > bestScore {
+			best = c
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+func (s *search) score(parent *node, c *node) float64 {
+	q := 0.0
+	if c.visits > 0 {
+		q = c.total / float64(c.visits)
+	}
+
+	if s.config.Prior != nil || s.config.Oracle != nil {
+		// PUCT
+		return q + s.config.C*c.prior*math.Sqrt(float64(parent.visits))/float64(1+c.visits)
+	}
+
+	// UCT, unvisited children first
+	if c.visits == 0 {
+		return math.Inf(1)
+	}
+	return q + s.config.C*math.Sqrt(math.Log(float64(parent.visits))/float64(c.visits))
+}
+
+// expand adds the children of n and returns the value of n for the player to move
+func (s *search) expand(n *node, root bool) (float64, error) {
+	moves := n.board.GetValidMoves()
+	var priors map[int]float64
+	var value float64
+	if s.config.Oracle != nil {
+		priors, value = s.config.Oracle.PriorValue(n.board)
+	} else if s.config.Prior != nil {
+		priors = s.config.Prior.Prior(n.board)
+	}
+
+	var noise []float64
+	if root && s.config.NoiseWeight > 0 {
+		noise = dirichlet(s.rand, s.config.NoiseAlpha, len(moves))
+	}
+
+	for i, m := range moves {
+		child, err := n.board.Move(m)
+		if err != nil {
+			return 0, err
+		}
+
+		prior := 1 / float64(len(moves))
+		if p, ok := priors[m]; ok {
+			prior = p
+		}
+		if noise != nil {
+			prior = (1-s.config.NoiseWeight)*prior + s.config.NoiseWeight*noise[i]
+		}
+
+		n.children = append(n.children, &node{board: child, move: m, prior: prior})
+	}
+
+	n.expanded = true
+	if s.config.Oracle != nil {
+		return value, nil
+	}
+
+	return s.leafValue(n.board)
+}
+
+func (s *search) leafValue(b *oware.Board) (float64, error) {
+	if s.config.Evaluator != nil {
+		return math.Tanh(s.config.Evaluator.Evaluate(b) / s.config.ValueScale), nil
+	}
+
+	return s.rollout(b)
+}
+
+// rollout plays the game out and returns the result for the player to move at b
+func (s *search) rollout(b *oware.Board) (float64, error) {
+	side := b.Player()
+	current := b
+	for i := 0; i < maxRollout && current.Status == oware.InProgress; i++ {
+		moves := current.GetValidMoves()
+		if len(moves) == 0 {
+			break
+		}
+
+		pit := moves[s.rand.Intn(len(moves))]
+		if s.config.Rollout != nil {
+			var err error
+			pit, _, err = s.config.Rollout.Choose(current)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		next, err := current.Move(pit)
+		if err != nil {
+			return 0, err
+		}
+		current = next
+	}
+
+	if current.Status == oware.InProgress {
+		scores := current.Scores()
+		return sign(scores[side] - scores[(side+1)%2]), nil
+	}
+
+	return player.Outcome(current, side), nil
+}
+
+// dirichlet samples n values from a symmetric Dirichlet distribution
+func dirichlet(r *rand.Rand, alpha float64, n int) []float64 {
+	values := make([]float64, n)
+	sum := 0.0
+	for i := range values {
+		values[i] = gamma(r, alpha)
+		sum += values[i]
+	}
+
+	for i := range values {
+		values[i] /= sum
+	}
+
+	return values
+}
+
+// gamma samples a Gamma(alpha, 1) variate with the Marsaglia and Tsang method
+func gamma(r *rand.Rand, alpha float64) float64 {
+	if alpha < 1 {
+		return gamma(r, alpha+1) * math.Pow(r.Float64(), 1/alpha)
+	}
+
+	d := alpha - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := r.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+
+		v = v * v * v
+		u := r.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// ValuePrior turns the evaluation of the position after every move into priors with a softmax.
+// Evaluations are for the player to move next, so they are negated.
+type ValuePrior struct {
+	Evaluator   Evaluator
+	Temperature float64
+}
+
+func (p *ValuePrior) Prior(b *oware.Board) map[int]float64 {
+	moves := b.GetValidMoves()
+	values := make(map[int]float64, len(moves))
+	max := math.Inf(-1)
+	for _, m := range moves {
+		child, err := b.Move(m)
+		if err != nil {
+			return nil
+		}
+		values[m] = -p.Evaluator.Evaluate(child)
+		max = math.Max(max, values[m])
+	}
+
+	sum := 0.0
+	priors := make(map[int]float64, len(values))
+	for m, v := range values {
+		priors[m] = math.Exp((v - max) / p.Temperature)
+		sum += priors[m]
+	}
+
+	for m := range priors {
+		priors[m] /= sum
+	}
+
+	return priors
+}
+
+func sign(x int) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,277 @@
+package minimax
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Antonite/oware"
+)
+
+// Terminal positions are worth more than any evaluation
+const win = 1e6
+
+// Values beyond mate are terminal scores that depend on the ply they were found at
+const mate = win / 2
+
+// Evaluator scores a board from the perspective of the player to move
+type Evaluator interface {
+	Evaluate(b *oware.Board) float64
+}
+
+// ScoreDifference is the captured seeds of the player to move minus the opponent's
+type ScoreDifference struct{}
+
+func (ScoreDifference) Evaluate(b *oware.Board) float64 {
+	scores := b.Scores()
+	p := b.Player()
+	return float64(scores[p] - scores[(p+1)%2])
+}
+
+type Config struct {
+	// Maximum search depth in plies
+	Depth int
+	// Stop deepening once the time runs out, unlimited when zero
+	TimeLimit time.Duration
+	// Leaf evaluation, ScoreDifference when nil
+	Evaluator Evaluator
+}
+
+// Engine is an iterative deepening alpha-beta search. It is safe for concurrent use.
+type Engine struct {
+	config Config
+}
+
+func New(config Config) *Engine {
+	if config.Evaluator == nil {
+		config.Evaluator = ScoreDifference{}
+	}
+	if config.Depth < 1 {
+		config.Depth = 1
+	}
+
+	return &Engine{config: config}
+}
+
+func (e *Engine) Name() string {
+	return fmt.Sprintf("minimax:%v", e.config.Depth)
+}
+
+// Choose returns the best pit and the searched value of every valid pit from the deepest completed iteration
+func (e *Engine) Choose(b *oware.Board) (int, map[int]float64, error) {
+	moves := b.GetValidMoves()
+	if len(moves) == 0 {
+		return -1, nil, errors.New("no valid moves found")
+	}
+
+	s := &search{
+		eval:  e.config.Evaluator,
+		table: make(map[string]*entry),
+	}
+	if e.config.TimeLimit > 0 {
+		s.deadline = time.Now().Add(e.config.TimeLimit)
+	}
+
+	best := moves[0]
+	var scores map[int]float64
+	for depth := 1; depth <= e.config.Depth; depth++ {
+		pit, values := s.root(b, depth)
+		if s.aborted {
+			break
+		}
+
+		best, scores = pit, values
+	}
+
+	return best, scores, nil
+}
+
+type bound int
+
+const (
+	exact bound = iota
+	lower
+	upper
+)
+
+// entry is a transposition table record keyed by Board.ToString.
+// Terminal scores are stored relative to the entry's node, see toTable.
+type entry struct {
+	depth int
+	value float64
+	bound bound
+	move  int
+}
+
+type search struct {
+	eval     Evaluator
+	table    map[string]*entry
+	deadline time.Time
+	nodes    int
+	aborted  bool
+}
+
+// root searches every move with a full window so each pit gets an exact value
+func (s *search) root(b *oware.Board, depth int) (int, map[int]float64) {
+	best := -1
+	values := make(map[int]float64)
+	for _, m := range s.order(b, -1) {
+		child, err := b.Move(m)
+		if err != nil {
+			continue
+		}
+
+		v := -s.negamax(child, depth-1, math.Inf(-1), math.Inf(1), 1)
+		values[m] = v
+		if best == -1 || v > values[best] {
+			best = m
+		}
+	}
+
+	s.table[b.ToString()] = &entry{depth: depth, value: values[best], bound: exact, move: best}
+	return best, values
+}
+
+func (s *search) negamax(b *oware.Board, depth int, alpha float64, beta float64, ply int) float64 {
+	if s.timedOut() {
+		return 0
+	}
+
+	if b.Status != oware.InProgress {
+		return terminal(b, ply)
+	}
+
+	if depth == 0 {
+		return s.eval.Evaluate(b)
+	}
+
+	key := b.ToString()
+	ttMove := -1
+	if e, ok := s.table[key]; ok {
+		ttMove = e.move
+		if e.depth >= depth {
+			value := fromTable(e.value, ply)
+			switch e.bound {
+			case exact:
+				return value
+			case lower:
+				alpha = math.Max(alpha, value)
+			case upper:
+				beta = math.Min(beta, value)
+			}
+			if alpha >= beta {
+				return value
+			}
+		}
+	}
+
+	original := alpha
+	best := math.Inf(-1)
+	bestMove := -1
+	for _, m := range s.order(b, ttMove) {
+		child, err := b.Move(m)
+		if err != nil {
+			continue
+		}
+
+		v := -s.negamax(child, depth-1, -beta, -alpha, ply+1)
+		if v > best {
+			best = v
+			bestMove = m
+		}
+
+		alpha = math.Max(alpha, v)
+		if alpha >= beta {
+			break
+		}
+	}
+
+	if s.aborted {
+		return 0
+	}
+
+	e := &entry{depth: depth, value: toTable(best, ply), bound: exact, move: bestMove}
+	if best <= original {
+		e.bound = upper
+	} else if best >= beta {
+		e.bound = lower
+	}
+	s.table[key] = e
+
+	return best
+}
+
+// toTable makes a terminal score relative to the node it is stored at instead of the root
+func toTable(v float64, ply int) float64 {
+	switch {
+	case v > mate:
+		return v + float64(ply)
+	case v < -mate:
+		return v - float64(ply)
+	default:
+		return v
+	}
+}
+
+// fromTable makes a stored terminal score relative to the root again at the ply it is probed from
+func fromTable(v float64, ply int) float64 {
+	switch {
+	case v > mate:
+		return v - float64(ply)
+	case v < -mate:
+		return v + float64(ply)
+	default:
+		return v
+	}
+}
+
+// order puts the transposition table move first, then moves by seeds captured
+func (s *search) order(b *oware.Board, ttMove int) []int {
+	moves := append([]int{}, b.GetValidMoves()...)
+	gains := make(map[int]int, len(moves))
+	p := b.Player()
+	for _, m := range moves {
+		child, err := b.Move(m)
+		if err != nil {
+			continue
+		}
+		gains[m] = child.Scores()[p] - b.Scores()[p]
+	}
+
+	sort.SliceStable(moves, func(i, j int) bool {
+		if moves[i] == ttMove || moves[j] == ttMove {
+			return moves[i] == ttMove
+		}
+		return gains[moves[i]] > gains[moves[j]]
+	})
+
+	return moves
+}
+
+func (s *search) timedOut() bool {
+	if s.aborted {
+		return true
+	}
+
+	s.nodes++
+	if !s.deadline.IsZero() && s.nodes%1024 == 0 && time.Now().After(s.deadline) {
+		s.aborted = true
+	}
+
+	return s.aborted
+}
+
+// terminal values a finished game for the player to move, preferring quicker wins
+func terminal(b *oware.Board, ply int) float64 {
+	p := b.Player()
+	switch {
+	case b.Status == oware.Player1Won && p == 0, b.Status == oware.Player2Won && p == 1:
+		return win - float64(ply)
+	case b.Status == oware.Tie:
+		return 0
+	default:
+		return -win + float64(ply)
+	}
+}
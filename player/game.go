@@ -0,0 +1,103 @@
+package player
+
+import (
+	"fmt"
+
+	"github.com/Antonite/oware"
+)
+
+// Game applies moves to a board and ends it when a player repeats a position
+type Game struct {
+	Board *oware.Board
+	Moves []int
+	// Repeated is set when the game was forcefully ended due to repetition
+	Repeated bool
+	played   [2]map[string]bool
+}
+
+func NewGame(b *oware.Board) *Game {
+	return &Game{
+		Board:  b,
+		played: [2]map[string]bool{make(map[string]bool), make(map[string]bool)},
+	}
+}
+
+func (g *Game) Over() bool {
+	return g.Board.Status != oware.InProgress
+}
+
+func (g *Game) Move(pit int) error {
+	if !isValid(g.Board, pit) {
+		return fmt.Errorf("invalid move: %v", pit)
+	}
+
+	nb, err := g.Board.Move(pit)
+	if err != nil {
+		return err
+	}
+
+	player := g.Board.Player()
+	key := nb.ToString()
+	if g.played[player][key] {
+		g.Board.ForceEndGame()
+		g.Repeated = true
+		return nil
+	}
+
+	g.played[player][key] = true
+	g.Board = nb
+	g.Moves = append(g.Moves, pit)
+	return nil
+}
+
+// Play runs a full game from the initial board. players[0] moves first.
+func Play(players [2]Player) (*Game, error) {
+	g := NewGame(oware.Initialize())
+	for !g.Over() {
+		p := players[g.Board.Player()]
+		pit, _, err := p.Choose(g.Board)
+		if err != nil {
+			return g, fmt.Errorf("%s failed to choose a move: %w", p.Name(), err)
+		}
+
+		if err := g.Move(pit); err != nil {
+			return g, fmt.Errorf("%s made a bad move: %w", p.Name(), err)
+		}
+	}
+
+	return g, nil
+}
+
+// Winner returns the winning side of a finished board or -1 for a tie or a game in progress
+func Winner(b *oware.Board) int {
+	switch b.Status {
+	case oware.Player1Won:
+		return 0
+	case oware.Player2Won:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// Outcome is the result of a finished board for side: 1 for a win, -1 for a loss and 0 for a tie
+func Outcome(b *oware.Board, side int) float64 {
+	switch Winner(b) {
+	case -1:
+		return 0
+	case side:
+		return 1
+	default:
+		return -1
+	}
+}
+
+func isValid(b *oware.Board, pit int) bool {
+	for _, m := range b.GetValidMoves() {
+		if m == pit {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package player
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/Antonite/oware"
+)
+
+// Human asks for moves on a line based input
+type Human struct {
+	input  *bufio.Scanner
+	output io.Writer
+}
+
+func NewHuman(in io.Reader, out io.Writer) *Human {
+	return &Human{
+		input:  bufio.NewScanner(in),
+		output: out,
+	}
+}
+
+func (h *Human) Name() string {
+	return "human"
+}
+
+func (h *Human) Choose(b *oware.Board) (int, map[int]float64, error) {
+	fmt.Fprintf(h.output, "Your turn. Valid pits: %v. Waiting for move selection...\n", b.GetValidMoves())
+	for h.input.Scan() {
+		pit, err := strconv.Atoi(h.input.Text())
+		if err != nil || !isValid(b, pit) {
+			fmt.Fprintln(h.output, "bad input, try again")
+			continue
+		}
+
+		return pit, nil, nil
+	}
+
+	if err := h.input.Err(); err != nil {
+		return -1, nil, err
+	}
+
+	return -1, nil, errors.New("input closed")
+}
@@ -0,0 +1,12 @@
+package player
+
+import (
+	"github.com/Antonite/oware"
+)
+
+// Player chooses moves for whichever side is to move
+type Player interface {
+	Name() string
+	// Choose returns the pit to play and optional scores for the valid pits
+	Choose(b *oware.Board) (int, map[int]float64, error)
+}
@@ -0,0 +1,28 @@
+package player
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/Antonite/oware"
+)
+
+// Random plays a uniformly random valid move
+type Random struct{}
+
+func NewRandom() *Random {
+	return &Random{}
+}
+
+func (r *Random) Name() string {
+	return "random"
+}
+
+func (r *Random) Choose(b *oware.Board) (int, map[int]float64, error) {
+	moves := b.GetValidMoves()
+	if len(moves) == 0 {
+		return -1, nil, errors.New("no valid moves found")
+	}
+
+	return moves[rand.Intn(len(moves))], nil, nil
+}
@@ -0,0 +1,390 @@
+package qdeepneuro
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/player"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// One policy output per pit of the side to move
+	pitCount         = 6
+	actorCriticMagic = "OWAREACN"
+)
+
+// ActorCritic learns a softmax policy over the six pits of the side to move, masked to the
+// valid moves, from full self-play episodes. A value network for the side to move is the
+// baseline: REINFORCE uses the episode's returns and the bootstrapped A2C variant uses
+// reward - gamma * value of the opponent's next position.
+type ActorCritic struct {
+	config Config
+	rand   *rand.Rand
+	seed   int64
+
+	// mu guards the networks while they are updated, saved or copied for players
+	mu              sync.Mutex
+	policy          *network
+	value           *network
+	policyOptimizer *optimizer
+	valueOptimizer  *optimizer
+	episodes        int
+}
+
+// EpisodeStats reports one training episode
+type EpisodeStats struct {
+	Episode    int
+	Plies      int
+	PolicyLoss float64
+	ValueLoss  float64
+	Entropy    float64
+}
+
+// acStep is one ply of an episode from the mover's side
+type acStep struct {
+	inputs []float64
+	mask   []float64
+	action int
+	reward float64
+}
+
+func NewActorCritic(config Config) *ActorCritic {
+	return newActorCritic(config, newPolicyNetwork(config), newNetwork(config))
+}
+
+func newActorCritic(config Config, policy *network, value *network) *ActorCritic {
+	return &ActorCritic{
+		config:          config,
+		rand:            rand.New(rand.NewSource(config.Seed)),
+		seed:            config.Seed,
+		policy:          policy,
+		value:           value,
+		policyOptimizer: newOptimizer(config.Optimizer),
+		valueOptimizer:  newOptimizer(config.Optimizer),
+	}
+}
+
+// newPolicyNetwork outputs the logits of the six pits
+func newPolicyNetwork(config Config) *network {
+	return newNetworkOutputs(config, pitCount, Linear)
+}
+
+func (a *ActorCritic) Config() Config {
+	return a.config
+}
+
+// Episodes is how many episodes have been trained on
+func (a *ActorCritic) Episodes() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.episodes
+}
+
+// Train plays and learns from episodes one at a time
+func (a *ActorCritic) Train(episodes int, report func(EpisodeStats)) error {
+	for e := 0; e < episodes; e++ {
+		steps, err := a.episode()
+		if err != nil {
+			return err
+		}
+
+		stats := a.update(steps)
+		if report != nil {
+			report(stats)
+		}
+	}
+
+	return nil
+}
+
+// episode plays a self-play game sampling moves from the policy
+func (a *ActorCritic) episode() ([]*acStep, error) {
+	steps := []*acStep{}
+	g := player.NewGame(oware.Initialize())
+	for !g.Over() {
+		b := g.Board
+		mover := b.Player()
+		before := b.Scores()[mover]
+
+		s := &acStep{inputs: a.policy.inputs(b), mask: pitMask(b)}
+		probs := a.policy.policy(s.inputs, s.mask)
+		s.action = sample(probs, a.rand)
+
+		if err := g.Move(s.action + pitCount*mover); err != nil {
+			return nil, err
+		}
+
+		s.reward = a.config.SeedReward * float64(g.Board.Scores()[mover]-before)
+		if g.Over() {
+			s.reward += a.config.WinReward * player.Outcome(g.Board, mover)
+		}
+		steps = append(steps, s)
+	}
+
+	return steps, nil
+}
+
+// update takes one step on each network from an episode
+func (a *ActorCritic) update(steps []*acStep) EpisodeStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rows := len(steps)
+	size := a.config.Encoding.Size()
+	inputs := make([]float64, 0, rows*size)
+	for _, s := range steps {
+		inputs = append(inputs, s.inputs...)
+	}
+	inputL := mat.NewDense(rows, size, inputs)
+	values := a.value.output(inputL)
+
+	// Each ply's return is from its mover's side, so the next ply's is subtracted
+	targets := mat.NewDense(rows, outputCount, nil)
+	next := 0.0
+	for t := rows - 1; t >= 0; t-- {
+		target := steps[t].reward - a.config.Gamma*next
+		targets.Set(t, 0, target)
+		if a.config.Bootstrap {
+			next = values.At(t, 0)
+		} else {
+			next = target
+		}
+	}
+
+	stats := EpisodeStats{Plies: rows}
+
+	// Policy gradient of -advantage*log(prob) - entropy bonus, taken at the logits
+	zs, as := a.policy.internalNeuro(inputL)
+	logits := as[len(as)-1]
+	delta := mat.NewDense(rows, pitCount, nil)
+	for t, s := range steps {
+		probs := softmax(logits.RawRowView(t), s.mask)
+		advantage := targets.At(t, 0) - values.At(t, 0)
+
+		entropy := 0.0
+		for _, p := range probs {
+			if p > 0 {
+				entropy -= p * math.Log(p)
+			}
+		}
+
+		for i, p := range probs {
+			if s.mask[i] == 0 {
+				continue
+			}
+			d := advantage * p
+			if i == s.action {
+				d -= advantage
+			}
+			if p > 0 {
+				d += a.config.Entropy * p * (math.Log(p) + entropy)
+			}
+			delta.Set(t, i, d/float64(rows))
+		}
+
+		stats.PolicyLoss -= advantage * math.Log(math.Max(probs[s.action], 1e-12)) / float64(rows)
+		stats.Entropy += entropy / float64(rows)
+		stats.ValueLoss += advantage * advantage / float64(rows)
+	}
+
+	a.policyOptimizer.update(a.policy, a.policy.backpropagate(inputL, zs, as, delta))
+	a.valueOptimizer.update(a.value, a.value.backward(inputL, targets, nil))
+
+	a.episodes++
+	stats.Episode = a.episodes
+	return stats
+}
+
+// policy is the probability of each of the side to move's pits
+func (n *network) policy(inputs []float64, mask []float64) []float64 {
+	logits := n.output(mat.NewDense(1, len(inputs), inputs))
+	return softmax(logits.RawRowView(0), mask)
+}
+
+// pitMask marks the valid pits of the side to move
+func pitMask(b *oware.Board) []float64 {
+	mask := make([]float64, pitCount)
+	for _, m := range b.GetValidMoves() {
+		mask[m-pitCount*b.Player()] = 1
+	}
+	return mask
+}
+
+func sample(probs []float64, r *rand.Rand) int {
+	p := r.Float64()
+	last := 0
+	for i, v := range probs {
+		if v == 0 {
+			continue
+		}
+		last = i
+		p -= v
+		if p < 0 {
+			return i
+		}
+	}
+	return last
+}
+
+// PolicyPlayer plays the most likely move of an actor-critic policy
+type PolicyPlayer struct {
+	policy *network
+	value  *network
+}
+
+// Player plays with a copy of the current networks
+func (a *ActorCritic) Player() *PolicyPlayer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &PolicyPlayer{policy: a.policy.clone(), value: a.value.clone()}
+}
+
+func (p *PolicyPlayer) Name() string {
+	return "actorcritic"
+}
+
+// Choose scores every valid move by its probability
+func (p *PolicyPlayer) Choose(b *oware.Board) (int, map[int]float64, error) {
+	valid := b.GetValidMoves()
+	if len(valid) == 0 {
+		return -1, nil, errors.New("no valid moves found")
+	}
+
+	probs := p.policy.policy(p.policy.inputs(b), pitMask(b))
+	scores := make(map[int]float64, len(valid))
+	best := valid[0]
+	for _, m := range valid {
+		scores[m] = probs[m-pitCount*b.Player()]
+		if scores[m] > scores[best] {
+			best = m
+		}
+	}
+
+	return best, scores, nil
+}
+
+// Evaluate is the value network's estimate for the player to move
+func (p *PolicyPlayer) Evaluate(b *oware.Board) float64 {
+	return p.value.value(b)
+}
+
+type actorCriticCheckpoint struct {
+	Config       Config
+	Policy       []savedLayer
+	Value        []savedLayer
+	PolicyFirst  [][]float64
+	PolicySecond [][]float64
+	ValueFirst   [][]float64
+	ValueSecond  [][]float64
+	PolicySteps  int
+	ValueSteps   int
+	Episodes     int
+	Seed         int64
+}
+
+// Save writes both networks, their optimizer state, the episode count and the seed
+func (a *ActorCritic) Save(w io.Writer) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c := &actorCriticCheckpoint{
+		Config:      a.config,
+		Policy:      saveLayers(a.policy),
+		Value:       saveLayers(a.value),
+		PolicySteps: a.policyOptimizer.steps,
+		ValueSteps:  a.valueOptimizer.steps,
+		Episodes:    a.episodes,
+		Seed:        a.seed,
+	}
+	c.PolicyFirst, c.PolicySecond = saveOptimizer(a.policyOptimizer, a.policy)
+	c.ValueFirst, c.ValueSecond = saveOptimizer(a.valueOptimizer, a.value)
+
+	if err := writeHeader(w, actorCriticMagic); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(c)
+}
+
+func (a *ActorCritic) SaveFile(path string) error {
+	return saveFile(path, a.Save)
+}
+
+// LoadActorCritic resumes training from a checkpoint. The encoding and architecture come from
+// the checkpoint, everything else from config.
+func LoadActorCritic(path string, config Config) (*ActorCritic, error) {
+	c, err := readActorCriticFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Encoding = c.Config.Encoding
+	config.Hidden = c.Config.Hidden
+	config.Output = c.Config.Output
+	config.Seed = c.Seed + int64(c.Episodes)
+
+	policy, value, err := c.networks(config)
+	if err != nil {
+		return nil, err
+	}
+
+	a := newActorCritic(config, policy, value)
+	a.seed = c.Seed
+	a.episodes = c.Episodes
+	a.policyOptimizer.steps = c.PolicySteps
+	a.valueOptimizer.steps = c.ValueSteps
+	restoreOptimizer(a.policyOptimizer, policy, c.PolicyFirst, c.PolicySecond)
+	restoreOptimizer(a.valueOptimizer, value, c.ValueFirst, c.ValueSecond)
+
+	return a, nil
+}
+
+// LoadPolicyPlayer plays with the networks of an actor-critic checkpoint
+func LoadPolicyPlayer(path string) (*PolicyPlayer, error) {
+	c, err := readActorCriticFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, value, err := c.networks(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PolicyPlayer{policy: policy, value: value}, nil
+}
+
+func (c *actorCriticCheckpoint) networks(config Config) (*network, *network, error) {
+	policy := newPolicyNetwork(config)
+	if err := restoreLayers(policy, c.Policy); err != nil {
+		return nil, nil, fmt.Errorf("policy: %w", err)
+	}
+
+	value := newNetwork(config)
+	if err := restoreLayers(value, c.Value); err != nil {
+		return nil, nil, fmt.Errorf("value: %w", err)
+	}
+
+	return policy, value, nil
+}
+
+func readActorCriticFile(path string) (*actorCriticCheckpoint, error) {
+	f, err := openCheckpoint(path, actorCriticMagic)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &actorCriticCheckpoint{}
+	if err := gob.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	return c, nil
+}
@@ -1,67 +1,83 @@
 package qdeepneuro
 
 import (
-	"fmt"
-	"time"
+	"math/rand"
 
 	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/player"
 )
 
+// agent plays self-play games with the latest published network and records every move for replay
 type agent struct {
-	board   *oware.Board
-	memory  *memory
-	network *network
+	config  Config
+	replay  *Replay
+	batcher *batcher
+	rand    *rand.Rand
 }
 
-func newAgent(network *network, memory *memory) *agent {
-	b := oware.Initialize()
+func newAgent(config Config, batcher *batcher, replay *Replay, r *rand.Rand) *agent {
 	return &agent{
-		board:   b,
-		memory:  memory,
-		network: network,
+		config:  config,
+		replay:  replay,
+		batcher: batcher,
+		rand:    r,
 	}
 }
 
-func (a *agent) play() {
-	fmt.Printf("starting board: %v\n", a.board)
-	board, move, err := a.network.forward(a.board)
-	if err != nil {
-		fmt.Printf("failed to forward. err: %v\n", err)
-		return
-	}
+// play runs one game, exploring with a random move with probability Epsilon
+func (a *agent) play() error {
+	g := player.NewGame(oware.Initialize())
+	for !g.Over() {
+		// The game ends a repeated position in place, so keep a copy of the position
+		state, err := oware.NewS(g.Board.ToString())
+		if err != nil {
+			return err
+		}
 
-	fmt.Printf("move 1 board: %v\n", board)
-	if board.Status != oware.InProgress {
-		// Award right away
-		return
-	}
+		move, err := a.choose(state)
+		if err != nil {
+			return err
+		}
 
-	board, move, err = a.network.forward(board)
-	if err != nil {
-		fmt.Printf("failed to forward. err: %v\n", err)
-		return
-	}
+		mover := state.Player()
+		before := state.Scores()[mover]
+		if err := g.Move(move); err != nil {
+			return err
+		}
 
-	fmt.Printf("move 2 board: %v\n", board)
+		t := &Transition{
+			State:    state,
+			Move:     move,
+			Next:     g.Board,
+			Terminal: g.Over(),
+			Reward:   a.config.SeedReward * float64(g.Board.Scores()[mover]-before),
+		}
+		if t.Terminal {
+			t.Reward += a.config.WinReward * player.Outcome(g.Board, mover)
+		}
 
-	// Record for future learning
-	a.memory.actions <- &action{a.board, board, move}
+		a.replay.Add(t)
+	}
 
-	time.Sleep(time.Second * 5)
+	return nil
+}
 
-	board, move, err = a.network.forward(oware.Initialize())
-	if err != nil {
-		fmt.Printf("failed to forward. err: %v\n", err)
-		return
+func (a *agent) choose(b *oware.Board) (int, error) {
+	valid := b.GetValidMoves()
+	if a.rand.Float64() < a.config.Epsilon {
+		return valid[a.rand.Intn(len(valid))], nil
 	}
-	fmt.Printf("move 1 board: %v\n", board)
-	board, move, err = a.network.forward(board)
+
+	scores, err := a.batcher.evaluate(b)
 	if err != nil {
-		fmt.Printf("failed to forward. err: %v\n", err)
-		return
+		return -1, err
 	}
 
-	fmt.Printf("move 2 board: %v\n", board)
-
-	a.memory.actions <- &action{a.board, board, move}
+	best := valid[0]
+	for _, m := range valid {
+		if scores[m] > scores[best] {
+			best = m
+		}
+	}
+	return best, nil
 }
@@ -0,0 +1,97 @@
+package qdeepneuro
+
+import "gonum.org/v1/gonum/mat"
+
+// gradients of the loss with respect to the weights and biases of every layer
+type gradients struct {
+	weights []*mat.Dense
+	biases  []*mat.Dense
+}
+
+// loss is half the mean squared error between the outputs and the targets
+func (n *network) loss(inputs *mat.Dense, targets *mat.Dense) float64 {
+	outputL := n.output(inputs)
+	rows := inputs.RawMatrix().Rows
+
+	sum := 0.0
+	for i := 0; i < rows; i++ {
+		for j := 0; j < outputCount; j++ {
+			d := outputL.At(i, j) - targets.At(i, j)
+			sum += d * d
+		}
+	}
+
+	return sum / float64(2*rows)
+}
+
+// backward computes the gradients of loss for a batch of inputs and targets.
+// Weights scale each row's error and may be nil to weigh every row the same.
+func (n *network) backward(inputs *mat.Dense, targets *mat.Dense, weights []float64) *gradients {
+	zs, as := n.internalNeuro(inputs)
+	rows, cols := targets.Dims()
+
+	// Output error
+	delta := mat.NewDense(rows, cols, nil)
+	delta.Sub(as[len(as)-1], targets)
+	delta.Scale(1/float64(rows), delta)
+	if weights != nil {
+		delta.Apply(func(r int, c int, v float64) float64 {
+			return v * weights[r]
+		}, delta)
+	}
+
+	return n.backpropagate(inputs, zs, as, delta)
+}
+
+// backpropagate computes the gradients from a forward pass and the gradient of the loss
+// with respect to the network's output. Delta is overwritten.
+func (n *network) backpropagate(inputs *mat.Dense, zs []*mat.Dense, as []*mat.Dense, delta *mat.Dense) *gradients {
+	rows := inputs.RawMatrix().Rows
+	last := len(n.layers) - 1
+
+	g := &gradients{
+		weights: make([]*mat.Dense, len(n.layers)),
+		biases:  make([]*mat.Dense, len(n.layers)),
+	}
+
+	for i := last; i >= 0; i-- {
+		l := n.layers[i]
+
+		// Through the activation
+		z := zs[i]
+		delta.Apply(func(r int, c int, v float64) float64 {
+			return v * l.activation.derivative(z.At(r, c))
+		}, delta)
+
+		input := inputs
+		if i > 0 {
+			input = as[i-1]
+		}
+
+		in, out := l.weights.Dims()
+		g.weights[i] = mat.NewDense(in, out, nil)
+		g.weights[i].Mul(input.T(), delta)
+		g.biases[i] = mat.NewDense(1, out, nil)
+		sumRows(g.biases[i], delta)
+
+		// Error at the previous layer's output
+		if i > 0 {
+			previous := mat.NewDense(rows, in, nil)
+			previous.Mul(delta, l.weights.T())
+			delta = previous
+		}
+	}
+
+	return g
+}
+
+func sumRows(dst *mat.Dense, src *mat.Dense) {
+	rows, cols := src.Dims()
+	for j := 0; j < cols; j++ {
+		sum := 0.0
+		for i := 0; i < rows; i++ {
+			sum += src.At(i, j)
+		}
+		dst.Set(0, j, sum)
+	}
+}
@@ -0,0 +1,80 @@
+package qdeepneuro
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestGradientCheck(t *testing.T) {
+	encodings := []string{"raw", "perspective,scaled", "onehot,derived", "thermometer"}
+	activations := []Activation{Linear, ReLU, LeakyReLU, Tanh, Sigmoid}
+
+	for _, enc := range encodings {
+		for _, activation := range activations {
+			encoding, err := ParseEncoding(enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := DefaultConfig()
+			config.Encoding = encoding
+			config.Hidden = []Layer{{Size: 8, Activation: activation}, {Size: 4, Activation: Tanh}}
+			config.Output = activation
+
+			rand.Seed(1)
+			if worst := gradientCheck(config, 8); worst > 1e-4 {
+				t.Errorf("%s %s: largest relative gradient error %v", enc, activation, worst)
+			}
+		}
+	}
+}
+
+// gradientCheck compares the backpropagated gradients of a random network and batch
+// against central finite differences and returns the largest relative error
+func gradientCheck(config Config, batch int) float64 {
+	n := newNetwork(config)
+	// Move the biases off zero so every parameter is exercised
+	for _, l := range n.layers {
+		l.biases.Apply(func(i, j int, v float64) float64 { return rand.Float64() - 0.5 }, l.biases)
+	}
+
+	inputs := mat.NewDense(batch, config.Encoding.Size(), nil)
+	inputs.Apply(func(i, j int, v float64) float64 { return rand.Float64() * 4 }, inputs)
+	targets := mat.NewDense(batch, outputCount, nil)
+	targets.Apply(func(i, j int, v float64) float64 { return rand.Float64()*2 - 1 }, targets)
+
+	g := n.backward(inputs, targets, nil)
+	pairs := [][2]*mat.Dense{}
+	for i, l := range n.layers {
+		pairs = append(pairs, [2]*mat.Dense{l.weights, g.weights[i]}, [2]*mat.Dense{l.biases, g.biases[i]})
+	}
+
+	const h = 1e-5
+	worst := 0.0
+	for _, p := range pairs {
+		params, grad := p[0], p[1]
+		rows, cols := params.Dims()
+		for i := 0; i < rows; i++ {
+			for j := 0; j < cols; j++ {
+				v := params.At(i, j)
+				params.Set(i, j, v+h)
+				plus := n.loss(inputs, targets)
+				params.Set(i, j, v-h)
+				minus := n.loss(inputs, targets)
+				params.Set(i, j, v)
+
+				numeric := (plus - minus) / (2 * h)
+				analytic := grad.At(i, j)
+				scale := math.Max(math.Abs(numeric)+math.Abs(analytic), 1e-6)
+				if e := math.Abs(numeric-analytic) / scale; e > worst {
+					worst = e
+				}
+			}
+		}
+	}
+
+	return worst
+}
@@ -0,0 +1,89 @@
+package qdeepneuro
+
+import (
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/Antonite/oware"
+)
+
+// Most rows merged into one forward pass
+const maxBatchRows = 1024
+
+// batcher merges evaluations requested by many goroutines into one forward pass. It takes
+// whatever requests are already waiting when it picks one up, so a lone caller is not delayed.
+type batcher struct {
+	network  func() *network
+	requests chan *request
+}
+
+type request struct {
+	inputs  []float64
+	rows    int
+	outputs chan []float64
+}
+
+func newBatcher(network func() *network) *batcher {
+	b := &batcher{
+		network:  network,
+		requests: make(chan *request, maxBatchRows),
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) run() {
+	for r := range b.requests {
+		batch := []*request{r}
+		rows := r.rows
+
+	drain:
+		for rows < maxBatchRows {
+			select {
+			case r := <-b.requests:
+				batch = append(batch, r)
+				rows += r.rows
+			default:
+				break drain
+			}
+		}
+
+		n := b.network()
+		inputs := make([]float64, 0, rows*n.config.Encoding.Size())
+		for _, r := range batch {
+			inputs = append(inputs, r.inputs...)
+		}
+
+		outputs := n.output(mat.NewDense(rows, n.config.Encoding.Size(), inputs)).RawMatrix().Data
+		for _, r := range batch {
+			r.outputs <- outputs[:r.rows]
+			outputs = outputs[r.rows:]
+		}
+	}
+}
+
+// values runs encoded rows through the network with other waiting requests
+func (b *batcher) values(inputs []float64, rows int) []float64 {
+	r := &request{inputs: inputs, rows: rows, outputs: make(chan []float64, 1)}
+	b.requests <- r
+	return <-r.outputs
+}
+
+// evaluate scores the position after every valid move
+func (b *batcher) evaluate(state *oware.Board) (map[int]float64, error) {
+	moves, inputs, err := b.network().afterstates(state)
+	if err != nil {
+		return nil, err
+	}
+
+	values := b.values(inputs, len(moves))
+	scores := make(map[int]float64, len(moves))
+	for i, m := range moves {
+		scores[m] = values[i]
+	}
+
+	return scores, nil
+}
+
+func (b *batcher) value(state *oware.Board) float64 {
+	return b.values(b.network().inputs(state), 1)[0]
+}
@@ -0,0 +1,256 @@
+package qdeepneuro
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	checkpointMagic   = "OWARENET"
+	checkpointVersion = uint16(1)
+)
+
+// checkpoint is everything needed to resume training: the architecture and training config,
+// the weights, the optimizer's running averages, the training step and the RNG seed.
+// The target network is not saved and restarts as a copy of the online network.
+type checkpoint struct {
+	Config Config
+	Layers []savedLayer
+	// Optimizer state per weight and bias matrix, in layer order. Nil when never updated.
+	First          [][]float64
+	Second         [][]float64
+	OptimizerSteps int
+	Step           int
+	Seed           int64
+}
+
+type savedLayer struct {
+	Inputs  int
+	Outputs int
+	Weights []float64
+	Biases  []float64
+}
+
+// Save writes a checkpoint of the learner
+func (l *Learner) Save(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := &checkpoint{
+		Config:         l.network.config,
+		Layers:         saveLayers(l.network),
+		OptimizerSteps: l.optimizer.steps,
+		Step:           l.steps,
+		Seed:           l.seed,
+	}
+	c.First, c.Second = saveOptimizer(l.optimizer, l.network)
+
+	if err := writeHeader(w, checkpointMagic); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(c)
+}
+
+// SaveFile writes a checkpoint next to path and renames it over path
+func (l *Learner) SaveFile(path string) error {
+	return saveFile(path, l.Save)
+}
+
+func saveFile(path string, save func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := save(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadLearner resumes training from a checkpoint. The encoding and architecture come from the checkpoint,
+// everything else from config. A new replay memory is used when replay is nil.
+func LoadLearner(path string, config Config, replay *Replay) (*Learner, error) {
+	c, err := readCheckpointFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := c.network()
+	if err != nil {
+		return nil, err
+	}
+
+	config.Encoding = c.Config.Encoding
+	config.Hidden = c.Config.Hidden
+	config.Output = c.Config.Output
+	// Continue the random sequence somewhere new rather than replaying it
+	config.Seed = c.Seed + int64(c.Step)
+	n.config = config
+
+	l := newLearner(config, n, replay)
+	l.seed = c.Seed
+	l.steps = c.Step
+	l.optimizer.steps = c.OptimizerSteps
+	restoreOptimizer(l.optimizer, n, c.First, c.Second)
+
+	go l.train(rand.New(rand.NewSource(l.rand.Int63())))
+
+	return l, nil
+}
+
+// LoadPlayer plays with the weights of a checkpoint
+func LoadPlayer(path string) (*Player, error) {
+	c, err := readCheckpointFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := c.network()
+	if err != nil {
+		return nil, err
+	}
+
+	return fixedPlayer(n), nil
+}
+
+// network rebuilds the weights, checking they match the architecture
+func (c *checkpoint) network() (*network, error) {
+	n := newNetwork(c.Config)
+	if err := restoreLayers(n, c.Layers); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func readCheckpointFile(path string) (*checkpoint, error) {
+	f, err := openCheckpoint(path, checkpointMagic)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &checkpoint{}
+	if err := gob.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	return c, nil
+}
+
+// openCheckpoint opens a file and reads past its header
+func openCheckpoint(path string, magic string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := readHeader(f, magic); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func saveLayers(n *network) []savedLayer {
+	layers := []savedLayer{}
+	for _, l := range n.layers {
+		in, out := l.weights.Dims()
+		layers = append(layers, savedLayer{
+			Inputs:  in,
+			Outputs: out,
+			Weights: append([]float64{}, l.weights.RawMatrix().Data...),
+			Biases:  append([]float64{}, l.biases.RawMatrix().Data...),
+		})
+	}
+	return layers
+}
+
+// restoreLayers copies saved weights into a network built with the same architecture
+func restoreLayers(n *network, layers []savedLayer) error {
+	if len(n.layers) != len(layers) {
+		return errors.New("checkpoint layers don't match its architecture")
+	}
+
+	for i, l := range n.layers {
+		s := layers[i]
+		in, out := l.weights.Dims()
+		if s.Inputs != in || s.Outputs != out || len(s.Weights) != in*out || len(s.Biases) != out {
+			return fmt.Errorf("checkpoint layer %v doesn't match its architecture", i)
+		}
+		l.weights = mat.NewDense(in, out, s.Weights)
+		l.biases = mat.NewDense(1, out, s.Biases)
+	}
+
+	return nil
+}
+
+// saveOptimizer lists the optimizer's running averages in the network's parameter order
+func saveOptimizer(o *optimizer, n *network) ([][]float64, [][]float64) {
+	first := [][]float64{}
+	second := [][]float64{}
+	for _, p := range n.params() {
+		first = append(first, rawData(o.first[p]))
+		second = append(second, rawData(o.second[p]))
+	}
+	return first, second
+}
+
+func restoreOptimizer(o *optimizer, n *network, first [][]float64, second [][]float64) {
+	for i, p := range n.params() {
+		r, c := p.Dims()
+		if i < len(first) && len(first[i]) == r*c {
+			o.first[p] = mat.NewDense(r, c, first[i])
+		}
+		if i < len(second) && len(second[i]) == r*c {
+			o.second[p] = mat.NewDense(r, c, second[i])
+		}
+	}
+}
+
+func writeHeader(w io.Writer, magic string) error {
+	header := make([]byte, len(magic)+2)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[len(magic):], checkpointVersion)
+	_, err := w.Write(header)
+	return err
+}
+
+func readHeader(r io.Reader, magic string) error {
+	header := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read checkpoint header: %w", err)
+	}
+
+	if string(header[:len(magic)]) != magic {
+		return errors.New("not an oware network checkpoint of this kind")
+	}
+
+	if v := binary.BigEndian.Uint16(header[len(magic):]); v != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version: %v", v)
+	}
+
+	return nil
+}
+
+func rawData(m *mat.Dense) []float64 {
+	if m == nil {
+		return nil
+	}
+	return append([]float64{}, m.RawMatrix().Data...)
+}
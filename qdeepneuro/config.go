@@ -0,0 +1,186 @@
+package qdeepneuro
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Activation is applied element-wise to the output of a layer
+type Activation int
+
+const (
+	Linear Activation = iota
+	ReLU
+	LeakyReLU
+	Tanh
+	Sigmoid
+)
+
+// Slope of leaky ReLU for negative inputs
+const leak = 0.01
+
+var activationNames = map[Activation]string{
+	Linear:    "linear",
+	ReLU:      "relu",
+	LeakyReLU: "leakyrelu",
+	Tanh:      "tanh",
+	Sigmoid:   "sigmoid",
+}
+
+func (a Activation) String() string {
+	return activationNames[a]
+}
+
+func ParseActivation(s string) (Activation, error) {
+	for a, name := range activationNames {
+		if name == s {
+			return a, nil
+		}
+	}
+	return Linear, fmt.Errorf("unknown activation: %s", s)
+}
+
+func (a Activation) apply(x float64) float64 {
+	switch a {
+	case ReLU:
+		return leru(x)
+	case LeakyReLU:
+		if x < 0 {
+			return leak * x
+		}
+		return x
+	case Tanh:
+		return math.Tanh(x)
+	case Sigmoid:
+		return 1 / (1 + math.Exp(-x))
+	default:
+		return x
+	}
+}
+
+// derivative is taken at the layer's input x
+func (a Activation) derivative(x float64) float64 {
+	switch a {
+	case ReLU:
+		return derLeru(x)
+	case LeakyReLU:
+		if x < 0 {
+			return leak
+		}
+		return 1
+	case Tanh:
+		t := math.Tanh(x)
+		return 1 - t*t
+	case Sigmoid:
+		s := 1 / (1 + math.Exp(-x))
+		return s * (1 - s)
+	default:
+		return 1
+	}
+}
+
+// Layer is one hidden layer of the network
+type Layer struct {
+	Size       int
+	Activation Activation
+}
+
+// Config describes the network architecture and how it is trained. The input layer is
+// sized by the board encoding and the output layer is a single value.
+type Config struct {
+	Encoding Encoding
+	Hidden   []Layer
+	// Activation of the output value
+	Output    Activation
+	Optimizer OptimizerConfig
+	// Transitions kept for replay and how many are trained on per update
+	ReplayCapacity int
+	BatchSize      int
+	// Prioritized samples transitions by their last error instead of uniformly
+	Prioritized   bool
+	PriorityAlpha float64
+	PriorityBeta  float64
+	// Discount on the opponent's best reply
+	Gamma float64
+	// Steps between copies of the online network to the target network
+	TargetSync int
+	// Polyak averaging rate for the target network, replaces TargetSync when above 0
+	Tau float64
+	// Double lets the online network choose the reply the target network values
+	Double bool
+	// Chance of a random move during self-play
+	Epsilon float64
+	// Reward for winning the game and for every seed captured
+	WinReward  float64
+	SeedReward float64
+	Seed       int64
+	// Entropy bonus weight and whether the actor-critic bootstraps from the value network
+	// instead of using episode returns
+	Entropy   float64
+	Bootstrap bool
+	// Self-play goroutines and the training steps between networks published to them
+	Workers      int
+	PublishEvery int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Hidden:         []Layer{{Size: 11, Activation: ReLU}},
+		Output:         Linear,
+		Optimizer:      DefaultOptimizerConfig(),
+		ReplayCapacity: 100000,
+		BatchSize:      32,
+		PriorityAlpha:  0.6,
+		PriorityBeta:   0.4,
+		Gamma:          0.99,
+		TargetSync:     1000,
+		Double:         true,
+		Epsilon:        0.1,
+		WinReward:      1,
+		SeedReward:     0.02,
+		Seed:           time.Now().UnixNano(),
+		Entropy:        0.01,
+		Workers:        4,
+		PublishEvery:   100,
+	}
+}
+
+// ParseLayers reads hidden layers written as size:activation separated by commas, e.g. 64:relu,32:tanh
+func ParseLayers(s string) ([]Layer, error) {
+	layers := []Layer{}
+	if s == "" {
+		return layers, nil
+	}
+
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.SplitN(spec, ":", 2)
+		size, err := strconv.Atoi(parts[0])
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid layer size: %s", parts[0])
+		}
+
+		activation := ReLU
+		if len(parts) > 1 {
+			activation, err = ParseActivation(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		layers = append(layers, Layer{Size: size, Activation: activation})
+	}
+
+	return layers, nil
+}
+
+// String writes the layers back in the ParseLayers format
+func (c Config) String() string {
+	specs := []string{}
+	for _, l := range c.Hidden {
+		specs = append(specs, fmt.Sprintf("%d:%s", l.Size, l.Activation))
+	}
+	return fmt.Sprintf("%s->%s->%s", c.Encoding, strings.Join(specs, ","), c.Output)
+}
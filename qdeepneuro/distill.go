@@ -0,0 +1,225 @@
+package qdeepneuro
+
+import (
+	"hash/crc32"
+	"math"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/qtable"
+	"github.com/Antonite/oware_rl/storage"
+	"gonum.org/v1/gonum/mat"
+)
+
+type DistillConfig struct {
+	Epochs    int
+	BatchSize int
+	// Share of positions held out for validation, chosen by key so it is the same every epoch
+	Validation float64
+	// Average fits the mean game result, (Reward - seed)/Games, for tables trained by broadcast
+	// where Reward sums game results on top of the position's seed. Otherwise Reward is fitted as it is.
+	Average bool
+	// LogVisits weights positions by log(1+Games) instead of Games
+	LogVisits bool
+}
+
+func DefaultDistillConfig() DistillConfig {
+	return DistillConfig{
+		Epochs:     10,
+		BatchSize:  256,
+		Validation: 0.1,
+		Average:    true,
+	}
+}
+
+// EpochLoss is the visit weighted mean squared error after an epoch
+type EpochLoss struct {
+	Epoch               int
+	Train               float64
+	Validation          float64
+	TrainPositions      int
+	ValidationPositions int
+}
+
+// Distill fits the network to the values stored in a qtable. The table stores values for the
+// player who moved into a position, the same as the network. Canonical tables only keep positions
+// with player 0 to move, so every position is fitted in both orientations. Records are streamed from the
+// store every epoch rather than loaded at once.
+func (l *Learner) Distill(store storage.Storage, config DistillConfig, report func(EpochLoss)) error {
+	for e := 1; e <= config.Epochs; e++ {
+		loss := EpochLoss{Epoch: e}
+
+		train := &distillBatch{}
+		err := store.Range(func(key string, state *storage.OwareState) error {
+			if state.Games == 0 || heldOut(key, config.Validation) {
+				return nil
+			}
+
+			boards := orientations(key)
+			if len(boards) == 0 {
+				return nil
+			}
+
+			for _, b := range boards {
+				train.add(l.network.inputs(b), target(boards[0], state, config), visits(state, config))
+			}
+			loss.TrainPositions++
+			if len(train.weights) >= config.BatchSize {
+				l.fit(train)
+				train = &distillBatch{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(train.weights) > 0 {
+			l.fit(train)
+		}
+
+		l.mu.Lock()
+		l.publish()
+		l.mu.Unlock()
+
+		loss.Train, _, err = l.distillLoss(store, config, false)
+		if err != nil {
+			return err
+		}
+		loss.Validation, loss.ValidationPositions, err = l.distillLoss(store, config, true)
+		if err != nil {
+			return err
+		}
+
+		if report != nil {
+			report(loss)
+		}
+	}
+
+	// Self-play training continues from the distilled values
+	l.mu.Lock()
+	l.target = l.network.clone()
+	l.mu.Unlock()
+
+	return nil
+}
+
+// distillLoss evaluates either the training or the held out positions with the published network
+func (l *Learner) distillLoss(store storage.Storage, config DistillConfig, validation bool) (float64, int, error) {
+	n := l.current()
+	sum := 0.0
+	weights := 0.0
+	count := 0
+
+	batch := &distillBatch{}
+	flush := func() {
+		outputL := n.output(mat.NewDense(len(batch.weights), n.config.Encoding.Size(), batch.inputs))
+		for i, w := range batch.weights {
+			d := outputL.At(i, 0) - batch.targets[i]
+			sum += w * d * d
+			weights += w
+		}
+		batch = &distillBatch{}
+	}
+
+	err := store.Range(func(key string, state *storage.OwareState) error {
+		if state.Games == 0 || heldOut(key, config.Validation) != validation {
+			return nil
+		}
+
+		boards := orientations(key)
+		if len(boards) == 0 {
+			return nil
+		}
+
+		for _, b := range boards {
+			batch.add(n.inputs(b), target(boards[0], state, config), visits(state, config))
+		}
+		count++
+		if len(batch.weights) >= config.BatchSize {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(batch.weights) > 0 {
+		flush()
+	}
+
+	if weights == 0 {
+		return 0, count, nil
+	}
+	return sum / weights, count, nil
+}
+
+// fit takes one optimizer step on a batch, weights scaled to average 1
+func (l *Learner) fit(b *distillBatch) {
+	total := 0.0
+	for _, w := range b.weights {
+		total += w
+	}
+	for i := range b.weights {
+		b.weights[i] *= float64(len(b.weights)) / total
+	}
+
+	rows := len(b.weights)
+	inputL := mat.NewDense(rows, l.network.config.Encoding.Size(), b.inputs)
+	targets := mat.NewDense(rows, outputCount, b.targets)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.optimizer.update(l.network, l.network.backward(inputL, targets, b.weights))
+	l.steps++
+}
+
+type distillBatch struct {
+	inputs  []float64
+	targets []float64
+	weights []float64
+}
+
+func (b *distillBatch) add(inputs []float64, target float64, weight float64) {
+	b.inputs = append(b.inputs, inputs...)
+	b.targets = append(b.targets, target)
+	b.weights = append(b.weights, weight)
+}
+
+func target(b *oware.Board, state *storage.OwareState, config DistillConfig) float64 {
+	if config.Average {
+		return qtable.Value(b, state, qtable.Broadcast)
+	}
+	return state.Reward
+}
+
+func visits(state *storage.OwareState, config DistillConfig) float64 {
+	if config.LogVisits {
+		return math.Log1p(float64(state.Games))
+	}
+	return float64(state.Games)
+}
+
+// orientations parses a key into the position and its mirror with the other player to move,
+// which have the same value. It is empty when the key doesn't parse.
+func orientations(key string) []*oware.Board {
+	b, err := oware.NewS(key)
+	if err != nil {
+		return nil
+	}
+
+	mirrored, err := storage.MirrorKey(key)
+	if err != nil {
+		return []*oware.Board{b}
+	}
+
+	mb, err := oware.NewS(mirrored)
+	if err != nil {
+		return []*oware.Board{b}
+	}
+
+	return []*oware.Board{b, mb}
+}
+
+// heldOut picks the validation positions by a hash of the key
+func heldOut(key string, share float64) bool {
+	return float64(crc32.ChecksumIEEE([]byte(key))%10000) < share*10000
+}
@@ -0,0 +1,203 @@
+package qdeepneuro
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Antonite/oware"
+)
+
+// Seeds selects how the seed count of each pit is fed to the network
+type Seeds int
+
+const (
+	// Counts feeds the number of seeds
+	Counts Seeds = iota
+	// OneHot sets one of bins units, the last one for bins-1 or more seeds
+	OneHot
+	// Thermometer sets the first n of bins-1 units for n seeds
+	Thermometer
+)
+
+// Pit counts above this share the last one-hot or thermometer unit
+const bins = 13
+
+// Encoding describes how a board becomes network inputs. The zero value is the raw
+// encoding: the player index, both scores and the seeds in every pit.
+type Encoding struct {
+	// Perspective orders pits and scores from the side to move and drops the player index
+	Perspective bool
+	// Scaled divides pit counts by 12 and scores by 25
+	Scaled bool
+	Seeds  Seeds
+	// Derived adds the seeds capturable from every pit by either player and the seeds on each side
+	Derived bool
+}
+
+// ParseEncoding reads a comma separated list of perspective, scaled, onehot, thermometer and derived.
+// An empty string is the raw encoding.
+func ParseEncoding(s string) (Encoding, error) {
+	e := Encoding{}
+	if s == "" || s == "raw" {
+		return e, nil
+	}
+
+	for _, f := range strings.Split(s, ",") {
+		switch f {
+		case "perspective":
+			e.Perspective = true
+		case "scaled":
+			e.Scaled = true
+		case "onehot":
+			e.Seeds = OneHot
+		case "thermometer":
+			e.Seeds = Thermometer
+		case "derived":
+			e.Derived = true
+		default:
+			return e, fmt.Errorf("unknown encoding feature: %s", f)
+		}
+	}
+
+	return e, nil
+}
+
+func (e Encoding) String() string {
+	features := []string{}
+	if e.Perspective {
+		features = append(features, "perspective")
+	}
+	if e.Scaled {
+		features = append(features, "scaled")
+	}
+	switch e.Seeds {
+	case OneHot:
+		features = append(features, "onehot")
+	case Thermometer:
+		features = append(features, "thermometer")
+	}
+	if e.Derived {
+		features = append(features, "derived")
+	}
+
+	if len(features) == 0 {
+		return "raw"
+	}
+	return strings.Join(features, ",")
+}
+
+// Size is the number of inputs the encoding produces
+func (e Encoding) Size() int {
+	size := 2
+	if !e.Perspective {
+		size++
+	}
+
+	switch e.Seeds {
+	case OneHot:
+		size += 12 * bins
+	case Thermometer:
+		size += 12 * (bins - 1)
+	default:
+		size += 12
+	}
+
+	if e.Derived {
+		size += 14
+	}
+
+	return size
+}
+
+func (e Encoding) encode(b *oware.Board) []float64 {
+	inputs := make([]float64, 0, e.Size())
+	pits := b.Pits()
+	scores := b.Scores()
+
+	// Pit and player order, starting with the side to move when in perspective
+	first := 0
+	if e.Perspective {
+		first = b.Player()
+	} else {
+		inputs = append(inputs, float64(b.Player()))
+	}
+
+	for _, p := range []int{first, (first + 1) % 2} {
+		inputs = append(inputs, e.scale(float64(scores[p]), 25))
+	}
+
+	for i := 0; i < 12; i++ {
+		seeds := pits[(i+6*first)%12]
+		switch e.Seeds {
+		case OneHot:
+			for k := 0; k < bins; k++ {
+				if seeds == k || k == bins-1 && seeds >= k {
+					inputs = append(inputs, 1)
+				} else {
+					inputs = append(inputs, 0)
+				}
+			}
+		case Thermometer:
+			for k := 0; k < bins-1; k++ {
+				if seeds > k {
+					inputs = append(inputs, 1)
+				} else {
+					inputs = append(inputs, 0)
+				}
+			}
+		default:
+			inputs = append(inputs, e.scale(float64(seeds), 12))
+		}
+	}
+
+	if e.Derived {
+		for _, p := range []int{first, (first + 1) % 2} {
+			inputs = append(inputs, captures(b, p)...)
+		}
+		for _, p := range []int{first, (first + 1) % 2} {
+			seeds := 0
+			for _, s := range pits[6*p : 6*p+6] {
+				seeds += s
+			}
+			inputs = append(inputs, e.scale(float64(seeds), 48))
+		}
+	}
+
+	return inputs
+}
+
+func (e Encoding) scale(v float64, by float64) float64 {
+	if e.Scaled {
+		return v / by
+	}
+	return v
+}
+
+// captures is the number of seeds player would capture by playing each of their pits,
+// as if it were their turn, divided by 12
+func captures(b *oware.Board, player int) []float64 {
+	out := make([]float64, 6)
+	if b.Status != oware.InProgress {
+		return out
+	}
+
+	tb, err := oware.New(player, append([]int{}, b.Scores()...), append([]int{}, b.Pits()...), nil, oware.InProgress)
+	if err != nil {
+		return out
+	}
+
+	for i := 0; i < 6; i++ {
+		pit := i + 6*player
+		if tb.Pits()[pit] == 0 {
+			continue
+		}
+
+		nb, err := tb.Move(pit)
+		if err != nil {
+			continue
+		}
+		out[i] = float64(nb.Scores()[player]-b.Scores()[player]) / 12
+	}
+
+	return out
+}
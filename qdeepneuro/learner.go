@@ -1,100 +1,241 @@
 package qdeepneuro
 
 import (
-	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"gonum.org/v1/gonum/mat"
 )
 
-const (
-	learners     int     = 1000
-	learningRate float64 = 1
-)
-
+// Learner trains the network with DQN: a pool of self-play workers fills the replay memory
+// while a trainer goroutine fits minibatches against a separate target network. The trainer
+// owns the network and publishes a copy every PublishEvery steps for the workers to play with.
+//
+// The network values a position for the player who moved into it, so the value of a move is
+// the value of the position it leads to and the opponent's best reply is subtracted:
+// target = reward - gamma * max over replies of target(next after reply).
 type Learner struct {
-	network *network
-	memory  *memory
+	config Config
+	replay *Replay
+	rand   *rand.Rand
+	seed   int64
+	// Latest published *network
+	snapshot atomic.Value
+	// Evaluates positions for the workers and players with the published network
+	batcher *batcher
+
+	// mu guards the trainer's state so checkpoints are consistent
+	mu        sync.Mutex
+	network   *network
+	target    *network
+	optimizer *optimizer
+	steps     int
 }
 
-func NewLeaner() *Learner {
-	l := &Learner{
-		network: newNetwork(),
-		memory:  newMemory(),
+// NewLeaner starts training a new network on the replay memory, a new one when replay is nil
+func NewLeaner(config Config, replay *Replay) *Learner {
+	l := newLearner(config, newNetwork(config), replay)
+	go l.train(rand.New(rand.NewSource(l.rand.Int63())))
+	return l
+}
+
+func newLearner(config Config, n *network, replay *Replay) *Learner {
+	if replay == nil {
+		replay = NewReplay(config.ReplayCapacity, config.PriorityAlpha)
 	}
 
-	for w := 1; w <= learners; w++ {
-		go l.remember()
+	l := &Learner{
+		config:    config,
+		network:   n,
+		target:    n.clone(),
+		optimizer: newOptimizer(config.Optimizer),
+		replay:    replay,
+		rand:      rand.New(rand.NewSource(config.Seed)),
+		seed:      config.Seed,
 	}
+	l.publish()
+	l.batcher = newBatcher(l.current)
 
 	return l
 }
 
-func (l *Learner) Learn() {
-	a := newAgent(l.network, l.memory)
-	a.play()
+func (l *Learner) Config() Config {
+	return l.config
+}
+
+func (l *Learner) Replay() *Replay {
+	return l.replay
+}
+
+// current is the latest published network
+func (l *Learner) current() *network {
+	return l.snapshot.Load().(*network)
+}
+
+func (l *Learner) publish() {
+	l.snapshot.Store(l.network.clone())
+}
+
+// Learn plays self-play games on Workers goroutines to feed the trainer
+func (l *Learner) Learn(games int) error {
+	queue := make(chan struct{})
+	go func() {
+		for g := 0; g < games; g++ {
+			queue <- struct{}{}
+		}
+		close(queue)
+	}()
+
+	workers := l.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	var firstErr error
+	for w := 0; w < workers; w++ {
+		a := newAgent(l.config, l.batcher, l.replay, rand.New(rand.NewSource(l.rand.Int63())))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range queue {
+				if err := a.play(); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// train repeatedly fits the network to minibatches sampled from the replay memory
+func (l *Learner) train(r *rand.Rand) {
+	for {
+		if l.replay.Len() < l.config.BatchSize {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		var batch *Batch
+		var err error
+		if l.config.Prioritized {
+			batch, err = l.replay.SamplePrioritized(l.config.BatchSize, l.config.PriorityBeta, r)
+		} else {
+			batch, err = l.replay.Sample(l.config.BatchSize, r)
+		}
+		if err != nil {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		l.mu.Lock()
+		errors := l.update(batch)
+		l.syncTarget()
+		l.mu.Unlock()
+
+		if l.config.Prioritized {
+			l.replay.UpdatePriorities(batch.Indexes, errors)
+		}
+	}
 }
 
-func (l *Learner) remember() {
-	acts := []*action{}
-	for act := range l.memory.actions {
-		acts = append(acts, act)
-		if len(acts) < 1 {
+// update takes one optimizer step on a batch and returns each transition's error
+func (l *Learner) update(batch *Batch) []float64 {
+	rows := len(batch.Transitions)
+
+	// The value of a move is the value of the position it led to
+	inputVector := []float64{}
+	// Every reply from those positions, for the targets
+	replyVector := []float64{}
+	replies := make([]int, rows)
+	for i, t := range batch.Transitions {
+		inputVector = append(inputVector, l.network.inputs(t.Next)...)
+		if t.Terminal {
 			continue
 		}
 
-		// Input layer
-		inputVector := []float64{}
-		experimentalInputVector := []float64{}
-		for _, a := range acts {
-			inputVector = append(inputVector, computeInputs(a.current)...)
-			experimentalInputVector = append(experimentalInputVector, computeInputs(a.new)...)
+		moves, inputs, err := l.network.afterstates(t.Next)
+		if err != nil {
+			continue
+		}
+		replyVector = append(replyVector, inputs...)
+		replies[i] = len(moves)
+	}
+
+	inputL := mat.NewDense(rows, l.network.config.Encoding.Size(), inputVector)
+
+	var targetL, onlineL *mat.Dense
+	if len(replyVector) > 0 {
+		replyL := mat.NewDense(len(replyVector)/l.network.config.Encoding.Size(), l.network.config.Encoding.Size(), replyVector)
+		targetL = l.target.output(replyL)
+		if l.config.Double {
+			onlineL = l.network.output(replyL)
+		}
+	}
+
+	targets := mat.NewDense(rows, outputCount, nil)
+	row := 0
+	for i, t := range batch.Transitions {
+		target := t.Reward
+		if replies[i] > 0 {
+			// Plain DQN takes the target network's best reply, Double DQN lets the
+			// online network pick the reply and the target network value it
+			best := row
+			for r := row; r < row+replies[i]; r++ {
+				if l.config.Double && onlineL.At(r, 0) > onlineL.At(best, 0) {
+					best = r
+				}
+				if !l.config.Double && targetL.At(r, 0) > targetL.At(best, 0) {
+					best = r
+				}
+			}
+			target -= l.config.Gamma * targetL.At(best, 0)
+			row += replies[i]
 		}
+		targets.Set(i, 0, target)
+	}
+
+	outputL := l.network.output(inputL)
+	errors := make([]float64, rows)
+	for i := range errors {
+		errors[i] = targets.At(i, 0) - outputL.At(i, 0)
+	}
+
+	l.optimizer.update(l.network, l.network.backward(inputL, targets, batch.Weights))
+	return errors
+}
 
-		inputL := mat.NewDense(len(acts), inputCount, inputVector)
-		eInputL := mat.NewDense(len(acts), inputCount, experimentalInputVector)
-
-		l.network.mu.Lock() // Lock weights
-		_, hiddenL, outputL := l.network.internalNeuro(inputL)
-		_, _, eOutputL := l.network.internalNeuro(eInputL)
-
-		// Find error rate
-		fmt.Printf("CURRENT: %v\n", outputL)
-		fmt.Printf("EXPERIMENTAL: %v\n", eOutputL)
-		outputL.Sub(eOutputL, outputL)
-		fmt.Printf("ERROR RATE: %v\n", outputL)
-
-		// Change output weights
-		chngOutV := make([]float64, weightCount*outputCount)
-		chngOut := mat.NewDense(weightCount, outputCount, chngOutV)
-		// fmt.Printf("chngOut layer: %v\n", chngOut)
-		// fmt.Printf("hiddenL layer: %v\n", hiddenL)
-		// fmt.Printf("outputL layer: %v\n", outputL)
-		chngOut.Mul(hiddenL.T(), outputL)
-		chngOut.Apply(func(i, j int, v float64) float64 { return -learningRate * v }, chngOut)
-		// Apply weight change
-		// fmt.Printf("chngOut layer: %v\n", chngOut)
-		fmt.Printf("weights before: %v\n", l.network.layer2Weights)
-		l.network.layer2Weights.Add(l.network.layer2Weights, chngOut)
-		fmt.Printf("weights after: %v\n", l.network.layer2Weights)
-
-		// // Change L1 weights
-		// chngOut2 := mat.NewDense(len(acts), weightCount, chngOutV)
-		// chngOut2.Mul(outputL, l.network.layer2Weights.T())
-		// // Derivative of hidden layer with respect to its input
-		// applyDerLeru(hiddenI)
-
-		// chngL1V := make([]float64, inputCount*weightCount)
-		// chngL1 := mat.NewDense(inputCount, weightCount, chngL1V)
-		// // fmt.Printf("hiddenI layer: %v\n", hiddenI)
-		// hiddenI.MulElem(hiddenI, chngOut2)
-		// chngL1.Mul(inputL.T(), hiddenI)
-		// // fmt.Printf("chngL1 layer: %v\n", chngL1)
-		// chngL1.Apply(func(i, j int, v float64) float64 { return -learningRate * v }, chngL1)
-		// // fmt.Printf("chngL1 layer: %v\n", chngL1)
-		// // fmt.Printf("weights before: %v\n", l.network.layer1Weights)
-		// l.network.layer1Weights.Add(l.network.layer1Weights, chngL1)
-		// // fmt.Printf("weights after: %v\n", l.network.layer1Weights)
-
-		l.network.mu.Unlock() // Unlock weights
+// syncTarget counts the step, publishes the network to the workers every PublishEvery steps and
+// moves the target network toward it, either by Polyak averaging every step when Tau is set or
+// by copying every TargetSync steps
+func (l *Learner) syncTarget() {
+	l.steps++
+	if l.config.PublishEvery > 0 && l.steps%l.config.PublishEvery == 0 {
+		l.publish()
 	}
+
+	if l.config.Tau > 0 {
+		l.target.blend(l.network, l.config.Tau)
+		return
+	}
+
+	if l.config.TargetSync > 0 && l.steps%l.config.TargetSync == 0 {
+		l.target = l.network.clone()
+	}
+}
+
+// Steps is how many minibatches have been trained on
+func (l *Learner) Steps() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.steps
 }
@@ -1,19 +1,295 @@
 package qdeepneuro
 
-import "github.com/Antonite/oware"
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
 
-type memory struct {
-	actions chan *action
+	"github.com/Antonite/oware"
+)
+
+// Transition is one move: the position it was played from, the reward it earned,
+// the position it led to and whether that position ended the game
+type Transition struct {
+	State    *oware.Board
+	Move     int
+	Reward   float64
+	Next     *oware.Board
+	Terminal bool
+}
+
+// Batch is a minibatch of transitions. Indexes identify them for UpdatePriorities and
+// Weights are the importance sampling corrections, all 1 for uniform sampling.
+type Batch struct {
+	Transitions []*Transition
+	Indexes     []int
+	Weights     []float64
+}
+
+// Replay is a fixed size ring buffer of transitions. Once full, the oldest are overwritten.
+// Prioritized sampling picks transitions in proportion to priority^alpha.
+type Replay struct {
+	mu          sync.Mutex
+	transitions []*Transition
+	next        int
+	size        int
+	alpha       float64
+	maxPriority float64
+	priorities  *sumTree
+}
+
+func NewReplay(capacity int, alpha float64) *Replay {
+	return &Replay{
+		transitions: make([]*Transition, capacity),
+		alpha:       alpha,
+		maxPriority: 1,
+		priorities:  newSumTree(capacity),
+	}
+}
+
+// Add stores a transition with the highest priority seen so it's sampled at least once
+func (r *Replay) Add(t *Transition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transitions[r.next] = t
+	r.priorities.set(r.next, math.Pow(r.maxPriority, r.alpha))
+	r.next = (r.next + 1) % len(r.transitions)
+	if r.size < len(r.transitions) {
+		r.size++
+	}
+}
+
+func (r *Replay) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
+
+// Sample picks n transitions uniformly with replacement
+func (r *Replay) Sample(n int, rnd *rand.Rand) (*Batch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size == 0 {
+		return nil, errors.New("replay memory is empty")
+	}
+
+	b := &Batch{}
+	for i := 0; i < n; i++ {
+		idx := rnd.Intn(r.size)
+		b.Transitions = append(b.Transitions, r.transitions[idx])
+		b.Indexes = append(b.Indexes, idx)
+		b.Weights = append(b.Weights, 1)
+	}
+
+	return b, nil
+}
+
+// SamplePrioritized picks n transitions in proportion to their priority. Beta sets how much of
+// the resulting bias the weights correct, 1 corrects it fully.
+func (r *Replay) SamplePrioritized(n int, beta float64, rnd *rand.Rand) (*Batch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := r.priorities.total()
+	if r.size == 0 || total <= 0 {
+		return nil, errors.New("replay memory is empty")
+	}
+
+	// The smallest probability gives the largest weight, which normalizes the rest
+	maxWeight := math.Pow(float64(r.size)*r.priorities.min()/total, -beta)
+
+	b := &Batch{}
+	segment := total / float64(n)
+	for i := 0; i < n; i++ {
+		// Stratified so a batch covers the whole range of priorities
+		idx := r.priorities.find(segment * (float64(i) + rnd.Float64()))
+		if idx >= r.size {
+			idx = r.size - 1
+		}
+
+		p := r.priorities.get(idx) / total
+		b.Transitions = append(b.Transitions, r.transitions[idx])
+		b.Indexes = append(b.Indexes, idx)
+		b.Weights = append(b.Weights, math.Pow(float64(r.size)*p, -beta)/maxWeight)
+	}
+
+	return b, nil
+}
+
+// UpdatePriorities sets the priorities of sampled transitions from their latest errors
+func (r *Replay) UpdatePriorities(indexes []int, errors []float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, idx := range indexes {
+		p := math.Abs(errors[i]) + 1e-6
+		if p > r.maxPriority {
+			r.maxPriority = p
+		}
+		r.priorities.set(idx, math.Pow(p, r.alpha))
+	}
+}
+
+type savedTransition struct {
+	State    string
+	Move     int
+	Reward   float64
+	Next     string
+	Terminal bool
+	Priority float64
+}
+
+type savedReplay struct {
+	Capacity    int
+	Alpha       float64
+	MaxPriority float64
+	// Oldest first
+	Transitions []savedTransition
+}
+
+// Save writes the buffer with boards in their string form
+func (r *Replay) Save(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := savedReplay{
+		Capacity:    len(r.transitions),
+		Alpha:       r.alpha,
+		MaxPriority: r.maxPriority,
+	}
+
+	start := 0
+	if r.size == len(r.transitions) {
+		start = r.next
+	}
+	for i := 0; i < r.size; i++ {
+		idx := (start + i) % len(r.transitions)
+		t := r.transitions[idx]
+		s.Transitions = append(s.Transitions, savedTransition{
+			State:    t.State.ToString(),
+			Move:     t.Move,
+			Reward:   t.Reward,
+			Next:     t.Next.ToString(),
+			Terminal: t.Terminal,
+			Priority: r.priorities.get(idx),
+		})
+	}
+
+	return gob.NewEncoder(w).Encode(&s)
+}
+
+// LoadReplay reads a buffer saved with Save into one sized for config. When the capacity
+// changed the newest transitions are kept. Priorities were raised to the saved alpha, so
+// a buffer saved with a different alpha is refused.
+func LoadReplay(rd io.Reader, config Config) (*Replay, error) {
+	var s savedReplay
+	if err := gob.NewDecoder(rd).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	if s.Capacity < 1 || len(s.Transitions) > s.Capacity {
+		return nil, errors.New("invalid replay memory")
+	}
+
+	if s.Alpha != config.PriorityAlpha {
+		return nil, fmt.Errorf("replay memory was saved with priority alpha %v, not %v", s.Alpha, config.PriorityAlpha)
+	}
+
+	if config.ReplayCapacity < 1 {
+		return nil, errors.New("replay capacity must be positive")
+	}
+
+	transitions := s.Transitions
+	if len(transitions) > config.ReplayCapacity {
+		transitions = transitions[len(transitions)-config.ReplayCapacity:]
+	}
+
+	r := NewReplay(config.ReplayCapacity, config.PriorityAlpha)
+	r.maxPriority = s.MaxPriority
+	for _, st := range transitions {
+		state, err := oware.NewS(st.State)
+		if err != nil {
+			return nil, err
+		}
+		next, err := oware.NewS(st.Next)
+		if err != nil {
+			return nil, err
+		}
+
+		r.transitions[r.next] = &Transition{state, st.Move, st.Reward, next, st.Terminal}
+		r.priorities.set(r.next, st.Priority)
+		r.next = (r.next + 1) % len(r.transitions)
+		r.size++
+	}
+
+	return r, nil
+}
+
+// sumTree is a binary tree whose nodes hold the sum and the minimum of their children's
+// priorities, so sampling and updates take log time
+type sumTree struct {
+	leaves int
+	sums   []float64
+	mins   []float64
+}
+
+func newSumTree(capacity int) *sumTree {
+	// A power of two keeps the leaves in order under the root
+	leaves := 1
+	for leaves < capacity {
+		leaves *= 2
+	}
+
+	t := &sumTree{
+		leaves: leaves,
+		sums:   make([]float64, 2*leaves),
+		mins:   make([]float64, 2*leaves),
+	}
+	for i := range t.mins {
+		t.mins[i] = math.Inf(1)
+	}
+	return t
+}
+
+func (t *sumTree) set(idx int, priority float64) {
+	i := idx + t.leaves
+	t.sums[i] = priority
+	t.mins[i] = priority
+	for i /= 2; i >= 1; i /= 2 {
+		t.sums[i] = t.sums[2*i] + t.sums[2*i+1]
+		t.mins[i] = math.Min(t.mins[2*i], t.mins[2*i+1])
+	}
+}
+
+func (t *sumTree) get(idx int) float64 {
+	return t.sums[idx+t.leaves]
+}
+
+func (t *sumTree) total() float64 {
+	return t.sums[1]
 }
 
-type action struct {
-	current *oware.Board
-	new     *oware.Board
-	move    int
+// min is the smallest priority that has been set
+func (t *sumTree) min() float64 {
+	return t.mins[1]
 }
 
-func newMemory() *memory {
-	return &memory{
-		actions: make(chan *action, 100000),
+// find returns the leaf where the running sum of priorities passes value
+func (t *sumTree) find(value float64) int {
+	i := 1
+	for i < t.leaves {
+		if value < t.sums[2*i] || t.sums[2*i+1] == 0 {
+			i = 2 * i
+		} else {
+			value -= t.sums[2*i]
+			i = 2*i + 1
+		}
 	}
+	return i - t.leaves
 }
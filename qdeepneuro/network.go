@@ -2,46 +2,95 @@ package qdeepneuro
 
 import (
 	"errors"
-	"fmt"
 	"math"
 	"math/rand"
-	"sync"
 
 	"github.com/Antonite/oware"
 	"gonum.org/v1/gonum/mat"
 )
 
-const (
-	inputCount  int = 15
-	weightCount int = 11
-	outputCount int = 1
-)
+const outputCount int = 1
 
+// network is updated in place by the trainer only. Workers and players read published
+// clones that are never written again, so forward passes take no lock.
 type network struct {
-	mu            sync.Mutex
-	layer1Weights *mat.Dense
-	layer2Weights *mat.Dense
-}
-
-func newNetwork() *network {
-	l1w := []float64{}
-	l2w := []float64{}
-	// Weights from input to hidden layer
-	for i := 0; i < inputCount; i++ {
-		for w := 0; w < weightCount; w++ {
-			l1w = append(l1w, rand.Float64()-0.5)
-		}
+	config Config
+	layers []*layer
+}
+
+// layer holds the weights into a layer, its biases and its activation
+type layer struct {
+	weights    *mat.Dense
+	biases     *mat.Dense
+	activation Activation
+}
+
+func newNetwork(config Config) *network {
+	return newNetworkOutputs(config, outputCount, config.Output)
+}
+
+// newNetworkOutputs builds the configured hidden layers with a different output layer
+func newNetworkOutputs(config Config, outputs int, activation Activation) *network {
+	n := &network{config: config}
+
+	in := config.Encoding.Size()
+	for _, h := range config.Hidden {
+		n.layers = append(n.layers, newLayer(in, h.Size, h.Activation))
+		in = h.Size
+	}
+	n.layers = append(n.layers, newLayer(in, outputs, activation))
+
+	return n
+}
+
+// clone copies the weights. The copy is not locked with the original.
+func (n *network) clone() *network {
+	c := &network{config: n.config}
+	for _, l := range n.layers {
+		c.layers = append(c.layers, &layer{
+			weights:    mat.DenseCopyOf(l.weights),
+			biases:     mat.DenseCopyOf(l.biases),
+			activation: l.activation,
+		})
+	}
+	return c
+}
+
+// params lists the weight and bias matrices of every layer
+func (n *network) params() []*mat.Dense {
+	params := []*mat.Dense{}
+	for _, l := range n.layers {
+		params = append(params, l.weights, l.biases)
+	}
+	return params
+}
+
+// blend moves every weight a fraction tau of the way toward from's
+func (n *network) blend(from *network, tau float64) {
+	for i, l := range n.layers {
+		l.weights.Scale(1-tau, l.weights)
+		l.weights.Apply(func(r, c int, v float64) float64 {
+			return v + tau*from.layers[i].weights.At(r, c)
+		}, l.weights)
+		l.biases.Scale(1-tau, l.biases)
+		l.biases.Apply(func(r, c int, v float64) float64 {
+			return v + tau*from.layers[i].biases.At(r, c)
+		}, l.biases)
 	}
-	// Weights from hidden layer to output layer
-	for i := 0; i < weightCount; i++ {
-		for w := 0; w < outputCount; w++ {
-			l2w = append(l2w, rand.Float64()-0.5)
+}
+
+func newLayer(in int, out int, activation Activation) *layer {
+	w := []float64{}
+	for i := 0; i < in; i++ {
+		for o := 0; o < out; o++ {
+			w = append(w, rand.Float64()-0.5)
 		}
 	}
 
-	return &network{
-		layer1Weights: mat.NewDense(inputCount, weightCount, l1w),
-		layer2Weights: mat.NewDense(weightCount, outputCount, l2w),
+	return &layer{
+		weights:    mat.NewDense(in, out, w),
+		biases:     mat.NewDense(1, out, nil),
+		activation: activation,
 	}
 }
 
@@ -59,98 +108,114 @@ func (n *network) forward(state *oware.Board) (*oware.Board, int, error) {
 	return nb, move, nil
 }
 
+// bestMove plays the move whose resulting position is valued highest
 func (n *network) bestMove(state *oware.Board) (int, error) {
-	// Valid moves
-	valid := state.GetValidMoves()
-	if len(valid) == 0 {
-		return -1, errors.New("no valid moves found")
+	scores, err := n.evaluate(state)
+	if err != nil {
+		return -1, err
+	}
+
+	return best(state, scores)
+}
+
+// evaluate scores the position after every valid move in one forward pass
+func (n *network) evaluate(state *oware.Board) (map[int]float64, error) {
+	moves, inputs, err := n.afterstates(state)
+	if err != nil {
+		return nil, err
 	}
 
-	movesV := []float64{}
-	moveNum := 0
-	for _, m := range valid {
+	outputL := n.output(mat.NewDense(len(moves), len(inputs)/len(moves), inputs))
+	scores := make(map[int]float64, len(moves))
+	for i, m := range moves {
+		scores[m] = outputL.At(i, 0)
+	}
+
+	return scores, nil
+}
+
+// afterstates encodes the position after every valid move, one row per move
+func (n *network) afterstates(state *oware.Board) ([]int, []float64, error) {
+	moves := state.GetValidMoves()
+	if len(moves) == 0 {
+		return nil, nil, errors.New("no valid moves found")
+	}
+
+	inputs := make([]float64, 0, len(moves)*n.config.Encoding.Size())
+	for _, m := range moves {
 		eb, err := state.Move(m)
 		if err != nil {
-			fmt.Printf("failed to make a move: %v err: %v\n", m, err)
-			return 0, err
+			return nil, nil, err
 		}
-		// Compute input layer
-		inputVector := computeInputs(eb)
-		inputL := mat.NewDense(1, len(inputVector), inputVector)
-		// Seed the board through the neural network
-		_, _, outputL := n.internalNeuro(inputL)
-		if len(outputL.RawMatrix().Data) > 1 {
-			fmt.Printf("too many values were calculated for a move: %v position: %v\n", m, eb)
-			return 0, err
+		inputs = append(inputs, n.inputs(eb)...)
+	}
+
+	return moves, inputs, nil
+}
+
+// best is the valid move with the highest score
+func best(state *oware.Board, scores map[int]float64) (int, error) {
+	best := -1
+	for _, m := range state.GetValidMoves() {
+		if best == -1 || scores[m] > scores[best] {
+			best = m
 		}
-		movesV = append(movesV, outputL.RawMatrix().Data[0])
-		moveNum++
-	}
-
-	fmt.Printf("move vector: %v\n", movesV)
-	moveMat := mat.NewDense(moveNum, 1, movesV)
-	max := reverseSoftmax(moveMat)
-	fmt.Printf("move softmax: %v\n", max)
-	return valid[0], nil
-	// // Compute move probability
-	// moveP := rand.Float64()
-	// // Select move
-	// valueSum := 0.0
-	// values := max.RawMatrix().Data
-
-	// for i, v := range valid {
-	// 	valueSum += values[i]
-	// 	if valueSum >= moveP {
-	// 		fmt.Printf("random value: %v\n", moveP)
-	// 		fmt.Printf("move value: %v\n", values[i])
-	// 		fmt.Printf("move taken: %v\n", v)
-	// 		return v, nil
-	// 	}
-	// }
-
-	// return -1, errors.New("failed to find a valid move")
-}
-
-func (n *network) internalNeuro(inputs *mat.Dense) (*mat.Dense, *mat.Dense, *mat.Dense) {
-	// Apply W(i->1) weights
-	rawHidden := make([]float64, weightCount*inputs.RawMatrix().Rows)
-	hiddenL := mat.NewDense(inputs.RawMatrix().Rows, weightCount, rawHidden)
-	hiddenL.Mul(inputs, n.layer1Weights)
-
-	hiddenInput := mat.NewDense(inputs.RawMatrix().Rows, weightCount, rawHidden)
-	hiddenInput.Copy(hiddenL)
-	// Apply Hidden Layer Activation functions
-	applyLeru(hiddenL)
-
-	// Apply W(1->o) weights
-	rawOutput := make([]float64, outputCount)
-	outputL := mat.NewDense(inputs.RawMatrix().Rows, outputCount, rawOutput)
-	outputL.Mul(hiddenL, n.layer2Weights)
-
-	return hiddenInput, hiddenL, outputL
-}
-
-func applyLeru(matrix *mat.Dense) {
-	matrix.Apply(func(i int, j int, v float64) float64 {
-		return leru(v)
-	}, matrix)
+	}
+
+	if best == -1 {
+		return -1, errors.New("no valid moves found")
+	}
+
+	return best, nil
+}
+
+func (n *network) value(state *oware.Board) float64 {
+	inputVector := n.inputs(state)
+	inputL := mat.NewDense(1, len(inputVector), inputVector)
+	outputL := n.output(inputL)
+	return outputL.At(0, 0)
+}
+
+// internalNeuro returns every layer's input before activation and output after activation.
+// The last output is the network's value.
+func (n *network) internalNeuro(inputs *mat.Dense) ([]*mat.Dense, []*mat.Dense) {
+	rows := inputs.RawMatrix().Rows
+	zs := make([]*mat.Dense, len(n.layers))
+	as := make([]*mat.Dense, len(n.layers))
+
+	previous := inputs
+	for i, l := range n.layers {
+		_, cols := l.weights.Dims()
+		zs[i] = mat.NewDense(rows, cols, nil)
+		zs[i].Mul(previous, l.weights)
+		addBiases(zs[i], l.biases)
+
+		as[i] = mat.DenseCopyOf(zs[i])
+		as[i].Apply(func(r int, c int, v float64) float64 {
+			return l.activation.apply(v)
+		}, as[i])
+		previous = as[i]
+	}
+
+	return zs, as
+}
+
+// output runs the inputs through the network
+func (n *network) output(inputs *mat.Dense) *mat.Dense {
+	_, as := n.internalNeuro(inputs)
+	return as[len(as)-1]
 }
 
-func applyDerLeru(matrix *mat.Dense) {
+// addBiases adds the bias row to every row of the matrix
+func addBiases(matrix *mat.Dense, biases *mat.Dense) {
 	matrix.Apply(func(i int, j int, v float64) float64 {
-		return derLeru(v)
+		return v + biases.At(0, j)
 	}, matrix)
 }
 
-func computeInputs(state *oware.Board) []float64 {
-	inputs := []float64{float64(state.Player())}
-	for _, s := range state.Scores() {
-		inputs = append(inputs, float64(s))
-	}
-	for _, p := range state.Pits() {
-		inputs = append(inputs, float64(p))
-	}
-	return inputs
+// inputs encodes a board with the network's encoding
+func (n *network) inputs(state *oware.Board) []float64 {
+	return n.config.Encoding.encode(state)
 }
 
 func leru(x float64) float64 {
@@ -169,36 +234,29 @@ func derLeru(x float64) float64 {
 	return 1
 }
 
-func softmax(matrix *mat.Dense) *mat.Dense {
-	max := mat.Max(matrix)
-	var sum float64
-	matrix.Apply(func(i int, j int, v float64) float64 {
-		n := v - max
-		sum += math.Exp(n)
-		return n
-	}, matrix)
-
-	resultMatrix := mat.NewDense(matrix.RawMatrix().Rows, matrix.RawMatrix().Cols, nil)
-	resultMatrix.Apply(func(i int, j int, v float64) float64 {
-		return math.Exp(v) / sum
-	}, matrix)
-
-	return resultMatrix
-}
-
-func reverseSoftmax(matrix *mat.Dense) *mat.Dense {
-	max := mat.Max(matrix)
-	var sum float64
-	matrix.Apply(func(i int, j int, v float64) float64 {
-		n := (v - max) * -1
-		sum += math.Exp(n)
-		return n
-	}, matrix)
+// softmax turns a row of logits into probabilities over the entries where mask is set
+func softmax(logits []float64, mask []float64) []float64 {
+	max := math.Inf(-1)
+	for i, v := range logits {
+		if mask[i] > 0 && v > max {
+			max = v
+		}
+	}
 
-	resultMatrix := mat.NewDense(matrix.RawMatrix().Rows, matrix.RawMatrix().Cols, nil)
-	resultMatrix.Apply(func(i int, j int, v float64) float64 {
-		return math.Exp(v) / sum
-	}, matrix)
+	probs := make([]float64, len(logits))
+	sum := 0.0
+	for i, v := range logits {
+		if mask[i] > 0 {
+			probs[i] = math.Exp(v - max)
+			sum += probs[i]
+		}
+	}
 
-	return resultMatrix
+	if sum == 0 {
+		return probs
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
 }
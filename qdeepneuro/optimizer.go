@@ -0,0 +1,215 @@
+package qdeepneuro
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Method selects how gradients are turned into weight updates
+type Method int
+
+const (
+	// SGD with optional momentum
+	SGD Method = iota
+	// RMSProp divides by a running average of squared gradients
+	RMSProp
+	// Adam keeps bias-corrected running averages of gradients and squared gradients
+	Adam
+)
+
+// Schedule changes the learning rate as training progresses
+type Schedule int
+
+const (
+	Constant Schedule = iota
+	// StepDecay multiplies the rate by DecayRate every DecaySteps updates
+	StepDecay
+	// ExponentialDecay multiplies the rate by DecayRate per DecaySteps updates, smoothly
+	ExponentialDecay
+	// Cosine anneals the rate to MinLearningRate over DecaySteps updates
+	Cosine
+)
+
+type OptimizerConfig struct {
+	Method       Method
+	LearningRate float64
+	// Momentum for SGD
+	Momentum float64
+	// Decay of the squared gradient average for RMSProp
+	Rho float64
+	// Decays of the gradient and squared gradient averages for Adam
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+	// L2 penalty added to the weight gradients, biases are not decayed
+	WeightDecay float64
+	// Gradients are rescaled when their global norm is above ClipNorm. 0 disables clipping
+	ClipNorm        float64
+	Schedule        Schedule
+	DecaySteps      int
+	DecayRate       float64
+	MinLearningRate float64
+}
+
+func DefaultOptimizerConfig() OptimizerConfig {
+	return OptimizerConfig{
+		Method:       SGD,
+		LearningRate: 0.001,
+		Momentum:     0.9,
+		Rho:          0.9,
+		Beta1:        0.9,
+		Beta2:        0.999,
+		Epsilon:      1e-8,
+		Schedule:     Constant,
+		DecaySteps:   10000,
+		DecayRate:    0.5,
+	}
+}
+
+func ParseMethod(s string) (Method, error) {
+	switch s {
+	case "sgd":
+		return SGD, nil
+	case "rmsprop":
+		return RMSProp, nil
+	case "adam":
+		return Adam, nil
+	default:
+		return SGD, fmt.Errorf("unknown optimizer: %s", s)
+	}
+}
+
+func ParseSchedule(s string) (Schedule, error) {
+	switch s {
+	case "constant":
+		return Constant, nil
+	case "step":
+		return StepDecay, nil
+	case "exponential":
+		return ExponentialDecay, nil
+	case "cosine":
+		return Cosine, nil
+	default:
+		return Constant, fmt.Errorf("unknown schedule: %s", s)
+	}
+}
+
+// optimizer keeps the running averages for every weight and bias matrix of a network
+type optimizer struct {
+	config OptimizerConfig
+	steps  int
+	first  map[*mat.Dense]*mat.Dense
+	second map[*mat.Dense]*mat.Dense
+}
+
+func newOptimizer(config OptimizerConfig) *optimizer {
+	return &optimizer{
+		config: config,
+		first:  map[*mat.Dense]*mat.Dense{},
+		second: map[*mat.Dense]*mat.Dense{},
+	}
+}
+
+// rate is the scheduled learning rate for the current step
+func (o *optimizer) rate() float64 {
+	c := o.config
+	if c.DecaySteps < 1 {
+		return c.LearningRate
+	}
+
+	rate := c.LearningRate
+	switch c.Schedule {
+	case StepDecay:
+		rate *= math.Pow(c.DecayRate, float64(o.steps/c.DecaySteps))
+	case ExponentialDecay:
+		rate *= math.Pow(c.DecayRate, float64(o.steps)/float64(c.DecaySteps))
+	case Cosine:
+		progress := math.Min(float64(o.steps)/float64(c.DecaySteps), 1)
+		rate = c.MinLearningRate + (rate-c.MinLearningRate)*(1+math.Cos(math.Pi*progress))/2
+	}
+
+	return math.Max(rate, c.MinLearningRate)
+}
+
+// update applies one step of gradients to every layer of the network
+func (o *optimizer) update(n *network, g *gradients) {
+	params := []*mat.Dense{}
+	grads := []*mat.Dense{}
+	for i, l := range n.layers {
+		// Decay is added to a copy so the caller's gradients are untouched
+		w := mat.DenseCopyOf(g.weights[i])
+		if o.config.WeightDecay > 0 {
+			w.Apply(func(r, c int, v float64) float64 {
+				return v + o.config.WeightDecay*l.weights.At(r, c)
+			}, w)
+		}
+		params = append(params, l.weights, l.biases)
+		grads = append(grads, w, mat.DenseCopyOf(g.biases[i]))
+	}
+
+	if o.config.ClipNorm > 0 {
+		norm := 0.0
+		for _, grad := range grads {
+			f := mat.Norm(grad, 2)
+			norm += f * f
+		}
+		norm = math.Sqrt(norm)
+		if norm > o.config.ClipNorm {
+			for _, grad := range grads {
+				grad.Scale(o.config.ClipNorm/norm, grad)
+			}
+		}
+	}
+
+	rate := o.rate()
+	o.steps++
+	for i, p := range params {
+		o.step(p, grads[i], rate)
+	}
+}
+
+func (o *optimizer) step(params *mat.Dense, grad *mat.Dense, rate float64) {
+	c := o.config
+	first := o.state(o.first, params)
+	second := o.state(o.second, params)
+
+	switch c.Method {
+	case RMSProp:
+		params.Apply(func(i, j int, v float64) float64 {
+			gr := grad.At(i, j)
+			s := c.Rho*second.At(i, j) + (1-c.Rho)*gr*gr
+			second.Set(i, j, s)
+			return v - rate*gr/(math.Sqrt(s)+c.Epsilon)
+		}, params)
+	case Adam:
+		correct1 := 1 - math.Pow(c.Beta1, float64(o.steps))
+		correct2 := 1 - math.Pow(c.Beta2, float64(o.steps))
+		params.Apply(func(i, j int, v float64) float64 {
+			gr := grad.At(i, j)
+			m := c.Beta1*first.At(i, j) + (1-c.Beta1)*gr
+			s := c.Beta2*second.At(i, j) + (1-c.Beta2)*gr*gr
+			first.Set(i, j, m)
+			second.Set(i, j, s)
+			return v - rate*(m/correct1)/(math.Sqrt(s/correct2)+c.Epsilon)
+		}, params)
+	default:
+		params.Apply(func(i, j int, v float64) float64 {
+			velocity := c.Momentum*first.At(i, j) + grad.At(i, j)
+			first.Set(i, j, velocity)
+			return v - rate*velocity
+		}, params)
+	}
+}
+
+// state returns the running average kept for params, creating it on first use
+func (o *optimizer) state(states map[*mat.Dense]*mat.Dense, params *mat.Dense) *mat.Dense {
+	s, ok := states[params]
+	if !ok {
+		r, c := params.Dims()
+		s = mat.NewDense(r, c, nil)
+		states[params] = s
+	}
+	return s
+}
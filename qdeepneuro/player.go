@@ -0,0 +1,47 @@
+package qdeepneuro
+
+import (
+	"github.com/Antonite/oware"
+)
+
+// Player plays the move whose resulting position the network values highest
+type Player struct {
+	batcher *batcher
+}
+
+func NewPlayer(config Config) *Player {
+	return fixedPlayer(newNetwork(config))
+}
+
+// Player plays with the latest network the learner has published, batched with the workers
+func (l *Learner) Player() *Player {
+	return &Player{batcher: l.batcher}
+}
+
+func fixedPlayer(n *network) *Player {
+	return &Player{batcher: newBatcher(func() *network { return n })}
+}
+
+func (p *Player) Name() string {
+	return "qdeepneuro"
+}
+
+func (p *Player) Choose(b *oware.Board) (int, map[int]float64, error) {
+	scores, err := p.batcher.evaluate(b)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	m, err := best(b, scores)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	return m, scores, nil
+}
+
+// Evaluate scores a board for the player to move. The network values positions
+// for the player who moved into them, so the output is negated.
+func (p *Player) Evaluate(b *oware.Board) float64 {
+	return -p.batcher.value(b)
+}
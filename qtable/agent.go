@@ -4,29 +4,41 @@ import (
 	"fmt"
 
 	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/player"
 	"github.com/Antonite/oware_rl/storage"
 )
 
 type Agent struct {
-	board   *oware.Board
-	p1Moves map[string]bool
-	p2Moves map[string]bool
-	store   *storage.Storage
+	board    *oware.Board
+	p1Moves  map[string]bool
+	p2Moves  map[string]bool
+	lastMove string
+	history  []step
+	store    storage.Storage
+	config   Config
 }
 
-func New(store *storage.Storage) *Agent {
+// step is a position reached during the game in the order it was played
+type step struct {
+	key string
+	// Best value available to the opponent from this position
+	next float64
+}
+
+func New(store storage.Storage, config Config) *Agent {
 	b := oware.Initialize()
 	return &Agent{
 		board:   b,
 		p1Moves: make(map[string]bool),
 		p2Moves: make(map[string]bool),
 		store:   store,
+		config:  config,
 	}
 }
 
-func PlayForever(store *storage.Storage, id int) {
+func PlayForever(store storage.Storage, config Config, id int) {
 	for {
-		a := New(store)
+		a := New(store, config)
 		a.Play()
 	}
 }
@@ -50,25 +62,33 @@ func (a *Agent) Play() {
 
 		// Get possible moves with reward values
 		moveMap := a.ExploreCurrentMoves(moves, sroot)
+		switch a.config.Mode {
+		case QLearning:
+			a.backup(moveMap)
+		case TDLambda:
+			if len(a.history) > 0 && len(moveMap) > 0 {
+				a.history[len(a.history)-1].next = bestValue(moveMap)
+			}
+		}
 
-		// Decide on best move
-		bestValue := 0
-		bestMove := ""
-		for k, v := range moveMap {
-			// Ensure this move hasn't been played yet
-			played := a.MovePlayed(k)
-			if !played && (bestMove == "" || v > bestValue) {
-				bestMove = k
-				bestValue = v
+		// Only consider moves that haven't been played yet
+		options := []Option{}
+		for k, o := range moveMap {
+			if !a.MovePlayed(k) {
+				options = append(options, o)
 			}
 		}
 
 		// Can only repeat, must end game
-		if bestMove == "" {
+		if len(options) == 0 {
 			a.board.ForceEndGame()
 			continue
 		}
 
+		// Decide on a move
+		sortOptions(options)
+		bestMove := options[a.explorer().Choose(options)].Key
+
 		// Record for reward distribution
 		a.RecordMove(bestMove)
 
@@ -83,34 +103,102 @@ func (a *Agent) Play() {
 	}
 
 	a.DistributeAwards()
+	a.explorer().GameOver()
+}
+
+func (a *Agent) explorer() Explorer {
+	if a.config.Explorer == nil {
+		return Greedy{}
+	}
+
+	return a.config.Explorer
 }
 
 func (a *Agent) DistributeAwards() {
+	switch a.config.Mode {
+	case QLearning:
+		a.backupOutcome()
+		return
+	case TDLambda:
+		a.backupReturns()
+		return
+	}
+
 	if a.board.Status == oware.Tie {
 		for m := range a.p1Moves {
-			a.store.PunishChan <- m
+			a.store.PunishChan() <- m
 		}
 		for m := range a.p2Moves {
-			a.store.PunishChan <- m
+			a.store.PunishChan() <- m
 		}
 	} else if a.board.Status == oware.Player1Won {
 		for m := range a.p1Moves {
-			a.store.RewardChan <- m
+			a.store.RewardChan() <- m
 		}
 		for m := range a.p2Moves {
-			a.store.PunishChan <- m
+			a.store.PunishChan() <- m
 		}
 	} else {
 		for m := range a.p2Moves {
-			a.store.RewardChan <- m
+			a.store.RewardChan() <- m
 		}
 		for m := range a.p1Moves {
-			a.store.PunishChan <- m
+			a.store.PunishChan() <- m
+		}
+	}
+}
+
+// backup moves the previous position toward the negated best value available to the opponent
+func (a *Agent) backup(moveMap map[string]Option) {
+	if a.lastMove == "" || len(moveMap) == 0 {
+		return
+	}
+
+	if err := a.store.SafeUpdateReward(a.lastMove, -a.config.Gamma*bestValue(moveMap), a.config.Alpha); err != nil {
+		fmt.Printf("failed to update reward: %s\n", a.lastMove)
+	}
+}
+
+// backupOutcome moves the final position toward the game result
+func (a *Agent) backupOutcome() {
+	if a.lastMove == "" {
+		return
+	}
+
+	// The final position was reached by the player who isn't to move
+	mover := (a.board.Player() + 1) % 2
+	if err := a.store.SafeUpdateReward(a.lastMove, player.Outcome(a.board, mover), a.config.Alpha); err != nil {
+		fmt.Printf("failed to update reward: %s\n", a.lastMove)
+	}
+}
+
+// backupReturns moves every played position toward its lambda-return.
+// Players alternate, so the opponent's return is negated at every step.
+func (a *Agent) backupReturns() {
+	if len(a.history) == 0 {
+		return
+	}
+
+	last := len(a.history) - 1
+	mover := (a.board.Player() + 1) % 2
+	ret := player.Outcome(a.board, mover)
+	returns := make([]float64, len(a.history))
+	returns[last] = ret
+	for i := last - 1; i >= 0; i-- {
+		ret = -a.config.Gamma * ((1-a.config.Lambda)*a.history[i].next + a.config.Lambda*ret)
+		returns[i] = ret
+	}
+
+	for i, s := range a.history {
+		if err := a.store.SafeUpdateReward(s.key, returns[i], a.config.Alpha); err != nil {
+			fmt.Printf("failed to update reward: %s\n", s.key)
 		}
 	}
 }
 
 func (a *Agent) RecordMove(move string) {
+	a.lastMove = move
+	a.history = append(a.history, step{key: move})
 	if a.board.Player() == 0 {
 		a.p1Moves[move] = true
 	} else {
@@ -128,8 +216,8 @@ func (a *Agent) MovePlayed(move string) bool {
 	return played
 }
 
-func (a *Agent) ExploreCurrentMoves(moves []int, sroot string) map[string]int {
-	var moveMap map[string]int
+func (a *Agent) ExploreCurrentMoves(moves []int, sroot string) map[string]Option {
+	var moveMap map[string]Option
 	// Get possible moves from history
 	state, err := a.store.Get(sroot)
 	if err != nil {
@@ -139,8 +227,8 @@ func (a *Agent) ExploreCurrentMoves(moves []int, sroot string) map[string]int {
 		for k := range moveMap {
 			children = append(children, k)
 		}
-		// Insert new record
-		a.store.Insert(sroot, &storage.OwareState{Reward: 0, Children: children})
+		// Insert new record, seeded like a child so every record carries its seed
+		a.store.Insert(sroot, &storage.OwareState{Reward: a.initialReward(a.board), Children: children})
 	} else if len(state.Children) == 0 {
 		// Children are empty
 		moveMap = a.processPossibleMoves(moves)
@@ -154,25 +242,26 @@ func (a *Agent) ExploreCurrentMoves(moves []int, sroot string) map[string]int {
 		}
 	} else {
 		// State and children exist, find out potential rewards
-		moveMap = make(map[string]int, len(state.Children))
+		moveMap = make(map[string]Option, len(state.Children))
 		for _, child := range state.Children {
 			cstate, err := a.store.Get(child)
-			reward := 0
+			option := Option{Key: child}
 			if err != nil {
 				fmt.Printf("failed to get child: %s\n", child)
 			} else {
-				reward = cstate.Reward
+				option.Reward = cstate.Reward
+				option.Games = cstate.Games
 			}
 
-			moveMap[child] = reward
+			moveMap[child] = option
 		}
 	}
 
 	return moveMap
 }
 
-func (a *Agent) processPossibleMoves(moves []int) map[string]int {
-	childrenMap := make(map[string]int, len(moves))
+func (a *Agent) processPossibleMoves(moves []int) map[string]Option {
+	childrenMap := make(map[string]Option, len(moves))
 	for _, m := range moves {
 		cb, err := a.board.Move(m)
 		if err != nil {
@@ -181,19 +270,8 @@ func (a *Agent) processPossibleMoves(moves []int) map[string]int {
 		}
 
 		cbs := cb.ToString()
-		var reward int
-		if cb.Status == oware.InProgress {
-			player := (cb.Player() + 1) % 2
-			reward = cb.Scores()[player]
-		} else if cb.Status == oware.Tie {
-			reward = 0
-		} else if cb.CurrentPlayerWon() {
-			reward = 1000
-		} else {
-			reward = -1000
-		}
-
-		childrenMap[cbs] = reward
+		reward := a.initialReward(cb)
+		childrenMap[cbs] = Option{Key: cbs, Reward: reward}
 		state := &storage.OwareState{
 			Reward: reward,
 		}
@@ -203,3 +281,67 @@ func (a *Agent) processPossibleMoves(moves []int) map[string]int {
 
 	return childrenMap
 }
+
+// initialReward seeds a new position from the perspective of the player who moved into it
+func (a *Agent) initialReward(cb *oware.Board) float64 {
+	return Seed(cb, a.config.Mode)
+}
+
+// Seed is the reward a new position is stored with in mode, for the player who moved into it.
+// Broadcast rewards keep their seed, so it has to be taken off Reward to get the game results.
+func Seed(cb *oware.Board, mode Mode) float64 {
+	mover := (cb.Player() + 1) % 2
+	if mode == QLearning || mode == TDLambda {
+		if cb.Status == oware.InProgress {
+			return 0
+		}
+		return player.Outcome(cb, mover)
+	}
+
+	var reward float64
+	if cb.Status == oware.InProgress {
+		reward = float64(cb.Scores()[mover])
+	} else if cb.Status == oware.Tie {
+		reward = 0
+	} else if cb.CurrentPlayerWon() {
+		reward = 1000
+	} else {
+		reward = -1000
+	}
+
+	return reward
+}
+
+// MergeReward combines the records of a position and its mirror learned in mode. Broadcast rewards
+// are sums of game results and both records carry the position's seed, so they are added with one
+// seed taken off. Other modes learn values, which are averaged by games.
+func MergeReward(mode Mode) storage.MergeReward {
+	return func(key string, a *storage.OwareState, b *storage.OwareState) float64 {
+		if mode == Broadcast {
+			seed := 0.0
+			if cb, err := oware.NewS(key); err == nil {
+				seed = Seed(cb, mode)
+			}
+			return a.Reward + b.Reward - seed
+		}
+
+		games := a.Games + b.Games
+		if games == 0 {
+			return (a.Reward + b.Reward) / 2
+		}
+		return (a.Reward*float64(a.Games) + b.Reward*float64(b.Games)) / float64(games)
+	}
+}
+
+func bestValue(moveMap map[string]Option) float64 {
+	first := true
+	best := 0.0
+	for _, o := range moveMap {
+		if first || o.Reward > best {
+			best = o.Reward
+			first = false
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,50 @@
+package qtable
+
+import "fmt"
+
+// Mode selects how the agent turns finished games into rewards
+type Mode int
+
+const (
+	// Broadcast adds +1/-1 to every position the winner/loser played
+	Broadcast Mode = iota
+	// QLearning moves each position toward the backed-up value of its successor
+	QLearning
+	// TDLambda moves each position toward its lambda-return at the end of the game
+	TDLambda
+)
+
+type Config struct {
+	Mode Mode
+	// Learning rate for QLearning and TDLambda
+	Alpha float64
+	// Discount for QLearning and TDLambda
+	Gamma float64
+	// Trace decay for TDLambda. 0 is one step TD and 1 is the Monte Carlo return
+	Lambda float64
+	// Move selection during self-play, greedy when nil
+	Explorer Explorer
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Mode:     Broadcast,
+		Alpha:    0.1,
+		Gamma:    0.99,
+		Lambda:   0.8,
+		Explorer: Greedy{},
+	}
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "broadcast":
+		return Broadcast, nil
+	case "qlearning":
+		return QLearning, nil
+	case "tdlambda":
+		return TDLambda, nil
+	default:
+		return Broadcast, fmt.Errorf("unknown mode: %s", s)
+	}
+}
@@ -0,0 +1,43 @@
+package qtable
+
+import (
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/storage"
+)
+
+// Evaluator values boards with the learned rewards in storage
+type Evaluator struct {
+	store  storage.Storage
+	config Config
+}
+
+// NewEvaluator reads rewards learned in config's mode
+func NewEvaluator(store storage.Storage, config Config) *Evaluator {
+	return &Evaluator{store: store, config: config}
+}
+
+// Evaluate scores a board in [-1, 1] for the player to move. Rewards are stored for the player
+// who moved into a position, so they are negated. Unknown positions are worth 0.
+func (e *Evaluator) Evaluate(b *oware.Board) float64 {
+	state, err := e.store.Get(b.ToString())
+	if err != nil {
+		return 0
+	}
+
+	return -Value(b, state, e.config.Mode)
+}
+
+// Value is a stored state's value in [-1, 1] for the player who moved into b. Broadcast rewards
+// sum game results on top of the position's seed, so the value is the mean result without the
+// seed and 0 before any game. Other modes learn the value itself.
+func Value(b *oware.Board, state *storage.OwareState, mode Mode) float64 {
+	if mode != Broadcast {
+		return state.Reward
+	}
+
+	if state.Games == 0 {
+		return 0
+	}
+
+	return (state.Reward - Seed(b, mode)) / float64(state.Games)
+}
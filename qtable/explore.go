@@ -0,0 +1,171 @@
+package qtable
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+// Option is a position reachable with one move, with its learned value
+type Option struct {
+	Key    string
+	Reward float64
+	Games  int
+}
+
+// Explorer picks which of the unplayed options to play during self-play.
+// Options are sorted by key so choices don't depend on map order.
+type Explorer interface {
+	Choose(options []Option) int
+	// GameOver is called after every finished game
+	GameOver()
+}
+
+// Greedy always picks the highest reward, preferring the first option on ties
+type Greedy struct{}
+
+func (Greedy) Choose(options []Option) int {
+	return best(options)
+}
+
+func (Greedy) GameOver() {}
+
+// EpsilonGreedy picks a random option with probability epsilon and the best option otherwise.
+// Epsilon decays after every game and never drops below Min.
+type EpsilonGreedy struct {
+	Epsilon float64
+	Decay   float64
+	Min     float64
+	games   int64
+}
+
+func (e *EpsilonGreedy) Choose(options []Option) int {
+	if rand.Float64() < e.current() {
+		return rand.Intn(len(options))
+	}
+
+	return best(options)
+}
+
+func (e *EpsilonGreedy) GameOver() {
+	atomic.AddInt64(&e.games, 1)
+}
+
+func (e *EpsilonGreedy) current() float64 {
+	games := atomic.LoadInt64(&e.games)
+	return math.Max(e.Min, e.Epsilon*math.Pow(e.Decay, float64(games)))
+}
+
+// Boltzmann samples options in proportion to exp(reward / temperature)
+type Boltzmann struct {
+	Temperature float64
+}
+
+func (b *Boltzmann) Choose(options []Option) int {
+	max := options[best(options)].Reward
+	weights := make([]float64, len(options))
+	sum := 0.0
+	for i, o := range options {
+		// Shift by the max to keep exp from overflowing
+		weights[i] = math.Exp((o.Reward - max) / b.Temperature)
+		sum += weights[i]
+	}
+
+	p := rand.Float64() * sum
+	for i, w := range weights {
+		p -= w
+		if p < 0 {
+			return i
+		}
+	}
+
+	return len(options) - 1
+}
+
+func (b *Boltzmann) GameOver() {}
+
+// UCB1 picks the option with the highest upper confidence bound using the visit counts in storage.
+// Unvisited options are always tried first. Rewards must be mean values, so UCB1 only works with
+// QLearning and TDLambda; Broadcast rewards are running sums.
+type UCB1 struct {
+	C float64
+}
+
+func (u *UCB1) Choose(options []Option) int {
+	total := 0
+	for i, o := range options {
+		if o.Games == 0 {
+			return i
+		}
+		total += o.Games
+	}
+
+	bestIndex := 0
+	bestBound := math.Inf(-1)
+	for i, o := range options {
+		bound := o.Reward + u.C*math.Sqrt(math.Log(float64(total))/float64(o.Games))
+		if bound > bestBound {
+			bestIndex = i
+			bestBound = bound
+		}
+	}
+
+	return bestIndex
+}
+
+func (u *UCB1) GameOver() {}
+
+type ExplorerParams struct {
+	Epsilon      float64
+	EpsilonDecay float64
+	EpsilonMin   float64
+	Temperature  float64
+	C            float64
+}
+
+func DefaultExplorerParams() ExplorerParams {
+	return ExplorerParams{
+		Epsilon:      0.2,
+		EpsilonDecay: 0.9999,
+		EpsilonMin:   0.01,
+		Temperature:  1,
+		C:            math.Sqrt2,
+	}
+}
+
+func ParseExplorer(s string, params ExplorerParams) (Explorer, error) {
+	switch s {
+	case "greedy":
+		return Greedy{}, nil
+	case "epsilon":
+		return &EpsilonGreedy{Epsilon: params.Epsilon, Decay: params.EpsilonDecay, Min: params.EpsilonMin}, nil
+	case "boltzmann":
+		if params.Temperature <= 0 {
+			return nil, fmt.Errorf("temperature must be positive: %v", params.Temperature)
+		}
+		return &Boltzmann{Temperature: params.Temperature}, nil
+	case "ucb1":
+		return &UCB1{C: params.C}, nil
+	default:
+		return nil, fmt.Errorf("unknown explorer: %s", s)
+	}
+}
+
+func best(options []Option) int {
+	bestIndex := 0
+	for i, o := range options {
+		if o.Reward > options[bestIndex].Reward {
+			bestIndex = i
+		}
+	}
+
+	return bestIndex
+}
+
+func sortOptions(options []Option) {
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Key < options[j].Key
+	})
+}
@@ -0,0 +1,54 @@
+package qtable
+
+import (
+	"errors"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/storage"
+)
+
+// Player plays the move leading to the highest learned reward. It only reads the store,
+// training and the client record positions with an Agent.
+type Player struct {
+	store  storage.Storage
+	config Config
+}
+
+func NewPlayer(store storage.Storage, config Config) *Player {
+	return &Player{
+		store:  store,
+		config: config,
+	}
+}
+
+func (p *Player) Name() string {
+	return "qtable"
+}
+
+func (p *Player) Choose(b *oware.Board) (int, map[int]float64, error) {
+	moves := b.GetValidMoves()
+	if len(moves) == 0 {
+		return -1, nil, errors.New("no valid moves found")
+	}
+
+	best := -1
+	scores := make(map[int]float64, len(moves))
+	for _, m := range moves {
+		nb, err := b.Move(m)
+		if err != nil {
+			return -1, nil, err
+		}
+
+		// Positions that aren't stored yet are worth what training would seed them with
+		scores[m] = Seed(nb, p.config.Mode)
+		if state, err := p.store.Get(nb.ToString()); err == nil {
+			scores[m] = state.Reward
+		}
+
+		if best == -1 || scores[m] > scores[best] {
+			best = m
+		}
+	}
+
+	return best, scores, nil
+}
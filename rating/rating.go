@@ -0,0 +1,189 @@
+package rating
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	initialElo = 1500
+	kFactor    = 32
+)
+
+// Game is one rated game. Score is A's result: 1 for a win, 0.5 for a tie and 0 for a loss.
+type Game struct {
+	Time  time.Time
+	A     string
+	B     string
+	Score float64
+}
+
+type Rating struct {
+	Name   string
+	Elo    float64
+	Games  int
+	Wins   int
+	Losses int
+	Ties   int
+}
+
+// Ledger is an append-only file of rated games. Ratings are rebuilt by replaying it.
+type Ledger struct {
+	mu      sync.Mutex
+	file    *os.File
+	ratings map[string]*Rating
+}
+
+func Open(path string) (*Ledger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ratings, err := replay(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Ledger{
+		file:    f,
+		ratings: ratings,
+	}, nil
+}
+
+// Read replays the ledger at path without keeping it open
+func Read(path string) ([]Rating, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Rating{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ratings, err := replay(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return sorted(ratings), nil
+}
+
+// Record appends a game and updates both ratings
+func (l *Ledger) Record(a, b string, score float64) error {
+	if score < 0 || score > 1 {
+		return fmt.Errorf("invalid score: %v", score)
+	}
+
+	g := &Game{Time: time.Now().UTC(), A: a, B: b, Score: score}
+	line, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	apply(l.ratings, g)
+	return nil
+}
+
+// Ratings returns every agent ordered from highest to lowest rating
+func (l *Ledger) Ratings() []Rating {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return sorted(l.ratings)
+}
+
+func (l *Ledger) Close() error {
+	return l.file.Close()
+}
+
+// Expected is A's expected score against B
+func Expected(a, b float64) float64 {
+	return 1 / (1 + math.Pow(10, (b-a)/400))
+}
+
+func replay(r io.Reader) (map[string]*Rating, error) {
+	ratings := make(map[string]*Rating)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var g Game
+		if err := json.Unmarshal(scanner.Bytes(), &g); err != nil {
+			return nil, fmt.Errorf("bad ledger line %v: %w", line, err)
+		}
+
+		apply(ratings, &g)
+	}
+
+	return ratings, scanner.Err()
+}
+
+func apply(ratings map[string]*Rating, g *Game) {
+	a := get(ratings, g.A)
+	b := get(ratings, g.B)
+
+	expected := Expected(a.Elo, b.Elo)
+	a.Elo += kFactor * (g.Score - expected)
+	b.Elo += kFactor * ((1 - g.Score) - (1 - expected))
+
+	a.Games++
+	b.Games++
+	switch g.Score {
+	case 1:
+		a.Wins++
+		b.Losses++
+	case 0:
+		a.Losses++
+		b.Wins++
+	default:
+		a.Ties++
+		b.Ties++
+	}
+}
+
+func get(ratings map[string]*Rating, name string) *Rating {
+	r, ok := ratings[name]
+	if !ok {
+		r = &Rating{Name: name, Elo: initialElo}
+		ratings[name] = r
+	}
+
+	return r
+}
+
+func sorted(ratings map[string]*Rating) []Rating {
+	list := make([]Rating, 0, len(ratings))
+	for _, r := range ratings {
+		list = append(list, *r)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Elo == list[j].Elo {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Elo > list[j].Elo
+	})
+
+	return list
+}
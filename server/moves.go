@@ -3,17 +3,17 @@ package server
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 
 	"github.com/Antonite/oware"
-	"github.com/Antonite/oware_rl/qtable"
 )
 
 type MovesResponse struct {
 	Id     string
 	Pit    int
-	Reward int
+	Reward float64
+	// Best is set on the move the agent would play
+	Best bool
 }
 
 func (s *Server) GetMovesHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,7 +27,11 @@ func (s *Server) GetMovesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	moves, err := s.getMoves(id)
+	moves, err := s.getMoves(id, r.URL.Query().Get("agent"))
+	if errors.Is(err, ErrUnknownAgent) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -43,38 +47,37 @@ func (s *Server) GetMovesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
-func (s *Server) getMoves(id string) ([]*MovesResponse, error) {
+func (s *Server) getMoves(id string, agent string) ([]*MovesResponse, error) {
 	mresponse := []*MovesResponse{}
 
-	a := qtable.New(s.store)
 	b, err := oware.NewS(id)
 	if err != nil {
 		return mresponse, err
 	}
 
-	a.SetBoard(b)
-	moves := a.Board().GetValidMoves()
+	p, err := s.agent(agent)
+	if err != nil {
+		return mresponse, err
+	}
 
 	// Get possible moves with reward values
-	moveMap := a.ExploreCurrentMoves(moves, id)
-	for _, m := range moves {
-		nb, err := a.Board().Move(m)
+	best, scores, err := p.Choose(b)
+	if err != nil {
+		return mresponse, err
+	}
+
+	for _, m := range b.GetValidMoves() {
+		nb, err := b.Move(m)
 		if err != nil {
 			continue
 		}
 
-		nbs := nb.ToString()
-
-		reward, ok := moveMap[nbs]
-		if !ok {
-			fmt.Printf("couldn't find move in storage: %s, for key: %s\n", nbs, id)
-			return mresponse, errors.New("couldn't find move in storage")
-		}
-
+		// Agents without scores report 0 for every move
 		mresponse = append(mresponse, &MovesResponse{
-			Id:     nbs,
+			Id:     nb.ToString(),
 			Pit:    m,
-			Reward: reward,
+			Reward: scores[m],
+			Best:   m == best,
 		})
 	}
 
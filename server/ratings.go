@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Antonite/oware_rl/rating"
+)
+
+func (s *Server) GetRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,OPTIONS,POST,PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Origin,Accept, X-Requested-With, Content-Type, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	ratings, err := s.getRatings(r.URL.Query().Get("agent"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(ratings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getRatings rereads the ledger so games recorded by other processes show up
+func (s *Server) getRatings(agent string) ([]rating.Rating, error) {
+	if s.ledger == "" {
+		return nil, errors.New("ratings are not enabled")
+	}
+
+	ratings, err := rating.Read(s.ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	if agent == "" {
+		return ratings, nil
+	}
+
+	for _, r := range ratings {
+		if r.Name == agent {
+			return []rating.Rating{r}, nil
+		}
+	}
+
+	return []rating.Rating{}, nil
+}
@@ -1,23 +1,63 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/Antonite/oware_rl/agents"
+	"github.com/Antonite/oware_rl/player"
+	"github.com/Antonite/oware_rl/qtable"
 	"github.com/Antonite/oware_rl/storage"
 )
 
+// ErrUnknownAgent is returned for agent specs the server wasn't started with
+var ErrUnknownAgent = errors.New("agent isn't served")
+
 type Server struct {
-	store *storage.Storage
+	store  storage.Storage
+	player player.Player
+	// Path of the rating ledger, empty when ratings are disabled
+	ledger string
+	// Agents allowed on /moves by spec, built once at start and only read after
+	agents map[string]player.Player
+}
+
+// New serves the agents in specs from the store. Requests can only ask for these specs.
+// Network is the qdeepneuro checkpoint for agents that don't name one.
+func New(store storage.Storage, ledger string, network string, specs []string) (*Server, error) {
+	env := agents.NewEnv(store)
+	env.Network = network
+
+	s := &Server{
+		store:  store,
+		player: qtable.NewPlayer(store, qtable.DefaultConfig()),
+		ledger: ledger,
+		agents: make(map[string]player.Player, len(specs)),
+	}
+
+	for _, spec := range specs {
+		p, err := agents.New(spec, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build agent %s: %w", spec, err)
+		}
+		s.agents[spec] = p
+	}
+
+	return s, nil
 }
 
-func New() *Server {
-	store, err := storage.Init(50)
-	if err != nil {
-		fmt.Println("failed to initialize storage")
-		panic(err)
+// agent returns the player for a spec, the qtable player when empty
+func (s *Server) agent(spec string) (player.Player, error) {
+	if spec == "" {
+		return s.player, nil
+	}
+
+	p, ok := s.agents[spec]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAgent, spec)
 	}
 
-	return &Server{store: store}
+	return p, nil
 }
 
 func (s *Server) Close() {
@@ -0,0 +1,246 @@
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/Antonite/oware_rl/storage"
+)
+
+// A snapshot file is a plain header followed by a gzip stream of records.
+// Every record is a 4 byte length, a 4 byte crc32 and a json payload.
+// A zero length record ends the stream and is followed by the record count.
+const (
+	magic   = "OWARESNP"
+	version = uint16(1)
+	// Refuse records larger than this, a state only holds a handful of children
+	maxRecord = 1 << 20
+)
+
+type Record struct {
+	Key   string
+	State *storage.OwareState
+}
+
+type Writer struct {
+	w     io.Writer
+	gz    *gzip.Writer
+	buf   *bufio.Writer
+	count uint64
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, len(magic)+2)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[len(magic):], version)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	return &Writer{
+		w:   w,
+		gz:  gz,
+		buf: bufio.NewWriter(gz),
+	}, nil
+}
+
+func (w *Writer) Write(rec *Record) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxRecord {
+		return fmt.Errorf("record too large: %s", rec.Key)
+	}
+
+	if err := writePrefix(w.buf, uint32(len(payload)), crc32.ChecksumIEEE(payload)); err != nil {
+		return err
+	}
+
+	if _, err := w.buf.Write(payload); err != nil {
+		return err
+	}
+
+	w.count++
+	return nil
+}
+
+// Close writes the trailer and finishes the gzip stream. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if err := writePrefix(w.buf, 0, 0); err != nil {
+		return err
+	}
+
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, w.count)
+	if _, err := w.buf.Write(count); err != nil {
+		return err
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+
+	return w.gz.Close()
+}
+
+func (w *Writer) Count() uint64 {
+	return w.count
+}
+
+type Reader struct {
+	gz    *gzip.Reader
+	buf   *bufio.Reader
+	count uint64
+	done  bool
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+
+	if string(header[:len(magic)]) != magic {
+		return nil, errors.New("not an oware snapshot")
+	}
+
+	if v := binary.BigEndian.Uint16(header[len(magic):]); v != version {
+		return nil, fmt.Errorf("unsupported snapshot version: %v", v)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		gz:  gz,
+		buf: bufio.NewReader(gz),
+	}, nil
+}
+
+// Read returns the next record or io.EOF once the trailer has been verified
+func (r *Reader) Read() (*Record, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(r.buf, prefix); err != nil {
+		return nil, fmt.Errorf("truncated snapshot: %w", err)
+	}
+
+	size := binary.BigEndian.Uint32(prefix)
+	if size == 0 {
+		return nil, r.readTrailer()
+	}
+
+	if size > maxRecord {
+		return nil, errors.New("corrupt snapshot: record too large")
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.buf, payload); err != nil {
+		return nil, fmt.Errorf("truncated snapshot: %w", err)
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(prefix[4:]) {
+		return nil, errors.New("corrupt snapshot: checksum mismatch")
+	}
+
+	var rec Record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, err
+	}
+
+	if rec.Key == "" || rec.State == nil {
+		return nil, errors.New("corrupt snapshot: empty record")
+	}
+
+	r.count++
+	return &rec, nil
+}
+
+func (r *Reader) readTrailer() error {
+	count := make([]byte, 8)
+	if _, err := io.ReadFull(r.buf, count); err != nil {
+		return fmt.Errorf("truncated snapshot: %w", err)
+	}
+
+	if c := binary.BigEndian.Uint64(count); c != r.count {
+		return fmt.Errorf("corrupt snapshot: expected %v records, read %v", c, r.count)
+	}
+
+	r.done = true
+	return io.EOF
+}
+
+func (r *Reader) Close() error {
+	return r.gz.Close()
+}
+
+// Export streams every state in the store to w
+func Export(store storage.Storage, w io.Writer) (uint64, error) {
+	sw, err := NewWriter(w)
+	if err != nil {
+		return 0, err
+	}
+
+	err = store.Range(func(key string, state *storage.OwareState) error {
+		return sw.Write(&Record{Key: key, State: state})
+	})
+	if err != nil {
+		return sw.Count(), err
+	}
+
+	return sw.Count(), sw.Close()
+}
+
+// Import streams every state from r into the store. States that already exist are merged with
+// merge, so canonical stores also combine mirrored positions from snapshots taken before keys were
+// canonical. Importing the same snapshot twice counts its games twice.
+func Import(store storage.Storage, r io.Reader, merge storage.MergeReward) (inserted int, merged int, err error) {
+	sr, err := NewReader(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer sr.Close()
+
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			return inserted, merged, nil
+		}
+		if err != nil {
+			return inserted, merged, err
+		}
+
+		m, err := store.SafeMerge(rec.Key, rec.State, merge)
+		if err != nil {
+			return inserted, merged, err
+		}
+
+		if m {
+			merged++
+		} else {
+			inserted++
+		}
+	}
+}
+
+func writePrefix(w io.Writer, size uint32, checksum uint32) error {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint32(prefix, size)
+	binary.BigEndian.PutUint32(prefix[4:], checksum)
+	_, err := w.Write(prefix)
+	return err
+}
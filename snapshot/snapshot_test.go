@@ -0,0 +1,177 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"reflect"
+	"testing"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/storage"
+)
+
+// sum adds up rewards, like broadcast training without seeds
+func sum(key string, a *storage.OwareState, b *storage.OwareState) float64 {
+	return a.Reward + b.Reward
+}
+
+func testStore(t *testing.T) *storage.Memory {
+	store := storage.NewMemory(0)
+	b := oware.Initialize()
+	for _, m := range b.GetValidMoves() {
+		child, err := b.Move(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state := &storage.OwareState{Reward: float64(m), Children: []string{b.ToString()}, Games: m + 1}
+		if err := store.Insert(child.ToString(), state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Insert(b.ToString(), &storage.OwareState{Reward: -1, Games: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func states(t *testing.T, store storage.Storage) map[string]*storage.OwareState {
+	all := map[string]*storage.OwareState{}
+	err := store.Range(func(key string, state *storage.OwareState) error {
+		all[key] = state
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return all
+}
+
+func TestRoundTrip(t *testing.T) {
+	from := testStore(t)
+	defer from.Close()
+
+	var buf bytes.Buffer
+	count, err := Export(from, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := states(t, from)
+	if int(count) != len(want) {
+		t.Errorf("exported %v states, want %v", count, len(want))
+	}
+
+	to := storage.NewMemory(0)
+	defer to.Close()
+	inserted, merged, err := Import(to, bytes.NewReader(buf.Bytes()), sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != len(want) || merged != 0 {
+		t.Errorf("imported %v new and %v merged states, want %v new", inserted, merged, len(want))
+	}
+	if got := states(t, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported states differ from exported ones:\ngot  %v\nwant %v", got, want)
+	}
+
+	// Importing again merges every state instead of skipping it
+	inserted, merged, err = Import(to, bytes.NewReader(buf.Bytes()), sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 || merged != len(want) {
+		t.Errorf("imported %v new and %v merged states, want %v merged", inserted, merged, len(want))
+	}
+	for key, state := range states(t, to) {
+		if state.Reward != 2*want[key].Reward || state.Games != 2*want[key].Games {
+			t.Errorf("%s: got %+v, want twice %+v", key, state, want[key])
+		}
+	}
+}
+
+// encode writes a snapshot by hand, mutating the uncompressed records and trailer with body
+func encode(t *testing.T, header []byte, body func([]byte) []byte) []byte {
+	b := oware.Initialize()
+	var raw bytes.Buffer
+	records := []*Record{
+		{Key: b.ToString(), State: &storage.OwareState{Reward: 1, Games: 1}},
+		{Key: b.ToString(), State: &storage.OwareState{Reward: 2, Games: 1}},
+	}
+	for _, rec := range records {
+		payload, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writePrefix(&raw, uint32(len(payload)), crc32.ChecksumIEEE(payload)); err != nil {
+			t.Fatal(err)
+		}
+		raw.Write(payload)
+	}
+
+	if err := writePrefix(&raw, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, uint64(len(records)))
+	raw.Write(count)
+
+	var out bytes.Buffer
+	out.Write(header)
+	gz := gzip.NewWriter(&out)
+	if _, err := gz.Write(body(raw.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Bytes()
+}
+
+func TestCorrupt(t *testing.T) {
+	header := func(m string, v uint16) []byte {
+		h := make([]byte, len(m)+2)
+		copy(h, m)
+		binary.BigEndian.PutUint16(h[len(m):], v)
+		return h
+	}
+	same := func(raw []byte) []byte { return raw }
+
+	tests := []struct {
+		name   string
+		header []byte
+		body   func([]byte) []byte
+		valid  bool
+	}{
+		{name: "valid", header: header(magic, version), body: same, valid: true},
+		{name: "truncated", header: header(magic, version), body: func(raw []byte) []byte { return raw[:len(raw)/2] }},
+		{name: "no trailer", header: header(magic, version), body: func(raw []byte) []byte { return raw[:len(raw)-16] }},
+		{name: "flipped crc", header: header(magic, version), body: func(raw []byte) []byte { raw[5] ^= 0xff; return raw }},
+		{name: "bad trailer count", header: header(magic, version), body: func(raw []byte) []byte { raw[len(raw)-1]++; return raw }},
+		{name: "wrong magic", header: header("OWARELOG", version), body: same},
+		{name: "wrong version", header: header(magic, version+1), body: same},
+		{name: "no header", header: []byte{}, body: same},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemory(0)
+			defer store.Close()
+
+			_, _, err := Import(store, bytes.NewReader(encode(t, tt.header, tt.body)), sum)
+			if tt.valid && err != nil {
+				t.Errorf("failed to import a valid snapshot: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("imported a corrupt snapshot")
+			}
+		})
+	}
+}
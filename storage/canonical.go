@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/Antonite/oware"
+)
+
+// Canonical stores every position from the perspective of the player to move, so a position
+// and its mirror with the other player to move share one record. Rewards are stored for the
+// player who moved into a position, which doesn't depend on which side that was.
+//
+// Children are passed and returned in the same orientation as the key they belong to.
+type Canonical struct {
+	channels
+	store Storage
+}
+
+// NewCanonical canonicalizes the keys of store. Store should be opened without workers,
+// the reward and punish workers run on the canonical store instead.
+func NewCanonical(store Storage, workers int) *Canonical {
+	c := &Canonical{store: store}
+	c.channels = newChannels(c, workers)
+	return c
+}
+
+func (c *Canonical) Close() {
+	c.channels.close()
+	c.store.Close()
+}
+
+func (c *Canonical) Get(key string) (*OwareState, error) {
+	ckey, flipped := CanonicalKey(key)
+	state, err := c.store.Get(ckey)
+	if err != nil {
+		return nil, err
+	}
+
+	if flipped {
+		state.Children = mirrorKeys(state.Children)
+	}
+	return state, nil
+}
+
+func (c *Canonical) Insert(key string, state *OwareState) error {
+	ckey, flipped := CanonicalKey(key)
+	return c.store.Insert(ckey, orient(state, flipped))
+}
+
+func (c *Canonical) SafeAddChildren(key string, children []string) error {
+	ckey, flipped := CanonicalKey(key)
+	if flipped {
+		children = mirrorKeys(children)
+	}
+
+	return c.store.SafeAddChildren(ckey, children)
+}
+
+func (c *Canonical) SafeAdjustReward(key string, adjustment float64) error {
+	ckey, _ := CanonicalKey(key)
+	return c.store.SafeAdjustReward(ckey, adjustment)
+}
+
+func (c *Canonical) SafeUpdateReward(key string, target float64, alpha float64) error {
+	ckey, _ := CanonicalKey(key)
+	return c.store.SafeUpdateReward(ckey, target, alpha)
+}
+
+func (c *Canonical) SafeMerge(key string, state *OwareState, merge MergeReward) (bool, error) {
+	ckey, flipped := CanonicalKey(key)
+	return c.store.SafeMerge(ckey, orient(state, flipped), merge)
+}
+
+// Range walks the canonical records, every key has player 0 to move
+func (c *Canonical) Range(fn func(key string, state *OwareState) error) error {
+	return c.store.Range(fn)
+}
+
+// CanonicalKey returns the key of the position seen by the player to move, and whether
+// the board had to be mirrored. Keys that don't parse are returned unchanged.
+func CanonicalKey(key string) (string, bool) {
+	if p, err := partition(key); err != nil || p == "0" {
+		return key, false
+	}
+
+	mirrored, err := MirrorKey(key)
+	if err != nil {
+		return key, false
+	}
+
+	return mirrored, true
+}
+
+// MirrorKey swaps the sides of a position: pits are rotated by 6, scores, the player to move
+// and the winner are swapped. Mirroring twice gives back the original key.
+func MirrorKey(key string) (string, error) {
+	b, err := oware.NewS(key)
+	if err != nil {
+		return "", err
+	}
+
+	pits := b.Pits()
+	mirrored := make([]int, len(pits))
+	for i, p := range pits {
+		mirrored[(i+6)%12] = p
+	}
+
+	scores := []int{b.Scores()[1], b.Scores()[0]}
+
+	moves := []int{}
+	for _, m := range b.GetValidMoves() {
+		moves = append(moves, (m+6)%12)
+	}
+
+	status := b.Status
+	switch status {
+	case oware.Player1Won:
+		status = oware.Player2Won
+	case oware.Player2Won:
+		status = oware.Player1Won
+	}
+
+	mb, err := oware.New((b.Player()+1)%2, scores, mirrored, moves, status)
+	if err != nil {
+		return "", err
+	}
+
+	return mb.ToString(), nil
+}
+
+func mirrorKeys(keys []string) []string {
+	mirrored := make([]string, 0, len(keys))
+	for _, k := range keys {
+		mk, err := MirrorKey(k)
+		if err != nil {
+			mk = k
+		}
+		mirrored = append(mirrored, mk)
+	}
+
+	return mirrored
+}
+
+// MergeReward combines the rewards of a position and its mirror, both stored under the
+// canonical key. How depends on how the table was trained, see qtable.MergeReward.
+type MergeReward func(key string, a *OwareState, b *OwareState) float64
+
+// Migrate copies the records of a store keyed by raw board strings into a canonical store one at
+// a time. Records of a position and its mirror, or records the canonical store already has, are
+// merged: games and children are combined and rewards with merge. It returns how many records
+// were inserted and how many were merged into existing ones.
+func Migrate(from Storage, to Storage, merge MergeReward) (inserted int, merged int, err error) {
+	err = from.Range(func(key string, state *OwareState) error {
+		ckey, flipped := CanonicalKey(key)
+		m, err := to.SafeMerge(ckey, orient(state, flipped), merge)
+		if err != nil {
+			return err
+		}
+
+		if m {
+			merged++
+		} else {
+			inserted++
+		}
+		return nil
+	})
+
+	return inserted, merged, err
+}
+
+// openingKeys are the positions after the first move of a game, with player 1 to move
+func openingKeys() []string {
+	b := oware.Initialize()
+	keys := []string{}
+	for _, m := range b.GetValidMoves() {
+		child, err := b.Move(m)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, child.ToString())
+	}
+
+	return keys
+}
+
+// orient returns a copy of state with the children mirrored when its key was
+func orient(state *OwareState, flipped bool) *OwareState {
+	children := state.Children
+	if flipped {
+		children = mirrorKeys(children)
+	}
+
+	return &OwareState{
+		Reward:   state.Reward,
+		Children: children,
+		Games:    state.Games,
+	}
+}
+
+// mergeState folds the record of a mirrored position into the record of the same canonical key
+func mergeState(key string, into *OwareState, from *OwareState, merge MergeReward) {
+	into.Reward = merge(key, into, from)
+	into.Games += from.Games
+	into.Children = union(into.Children, from.Children)
+}
+
+// union returns the keys in either list, sorted
+func union(a []string, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	keys := []string{}
+	for _, k := range append(append([]string{}, a...), b...) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,247 @@
+package storage_test
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/Antonite/oware"
+	"github.com/Antonite/oware_rl/qtable"
+	"github.com/Antonite/oware_rl/storage"
+)
+
+// seeded plays the first valid move until the player who moved into the position has captured
+// seeds, so broadcast rewards of the position carry a seed
+func seeded(t *testing.T) *oware.Board {
+	b := oware.Initialize()
+	for qtable.Seed(b, qtable.Broadcast) == 0 {
+		var err error
+		if b, err = b.Move(b.GetValidMoves()[0]); err != nil || b.Status != oware.InProgress {
+			t.Fatalf("no seeded position: %v", err)
+		}
+	}
+
+	return b
+}
+
+func mirror(t *testing.T, key string) string {
+	m, err := storage.MirrorKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestMirrorKey(t *testing.T) {
+	b := oware.Initialize()
+	opening, err := b.Move(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	won, err := oware.New(1, []int{25, 20}, make([]int, 12), []int{}, oware.Player1Won)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		board *oware.Board
+	}{
+		{name: "start", board: b},
+		{name: "opening", board: opening},
+		{name: "scores", board: seeded(t)},
+		{name: "won", board: won},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.board.ToString()
+			mirrored := mirror(t, key)
+			if mirrored == key {
+				t.Fatalf("mirror of %s is the same key", key)
+			}
+			if back := mirror(t, mirrored); back != key {
+				t.Errorf("mirroring twice gave %s, want %s", back, key)
+			}
+
+			mb, err := oware.NewS(mirrored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mb.Player() == tt.board.Player() || mb.Scores()[0] != tt.board.Scores()[1] || mb.Pits()[0] != tt.board.Pits()[6] {
+				t.Errorf("%s isn't the mirror of %s", mirrored, key)
+			}
+
+			ckey, flipped := storage.CanonicalKey(key)
+			if flipped != (tt.board.Player() == 1) || ckey[2:3] != "0" {
+				t.Errorf("canonical key of %s is %s, flipped %v", key, ckey, flipped)
+			}
+		})
+	}
+}
+
+func TestMergeMirrored(t *testing.T) {
+	b := seeded(t)
+	key := b.ToString()
+	seed := qtable.Seed(b, qtable.Broadcast)
+	child, err := b.Move(b.GetValidMoves()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mode   qtable.Mode
+		a      float64
+		b      float64
+		reward float64
+	}{
+		// Both records carry the seed, the merged record only once
+		{name: "broadcast", mode: qtable.Broadcast, a: seed + 2, b: seed - 3, reward: seed - 1},
+		// Values are averaged by games
+		{name: "qlearning", mode: qtable.QLearning, a: 0.5, b: -0.1, reward: 0.05},
+		{name: "tdlambda", mode: qtable.TDLambda, a: -1, b: 1, reward: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewCanonical(storage.NewMemory(0), 0)
+			defer store.Close()
+
+			merge := qtable.MergeReward(tt.mode)
+			// The same position seen once with each player to move, children in its own orientation
+			if m, err := store.SafeMerge(key, &storage.OwareState{Reward: tt.a, Games: 1}, merge); err != nil || m {
+				t.Fatalf("first record merged %v, %v", m, err)
+			}
+			mstate := &storage.OwareState{Reward: tt.b, Games: 3, Children: []string{mirror(t, child.ToString())}}
+			if m, err := store.SafeMerge(mirror(t, key), mstate, merge); err != nil || !m {
+				t.Fatalf("mirrored record merged %v, %v", m, err)
+			}
+
+			for _, k := range []string{key, mirror(t, key)} {
+				state, err := store.Get(k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if math.Abs(state.Reward-tt.reward) > 1e-9 || state.Games != 4 {
+					t.Errorf("%s: got reward %v after %v games, want %v after 4", k, state.Reward, state.Games, tt.reward)
+				}
+
+				want := child.ToString()
+				if k != key {
+					want = mirror(t, want)
+				}
+				if len(state.Children) != 1 || state.Children[0] != want {
+					t.Errorf("%s: got children %v, want %s", k, state.Children, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRootSeeded(t *testing.T) {
+	b := seeded(t)
+	store := storage.NewCanonical(storage.NewMemory(0), 0)
+	defer store.Close()
+
+	a := qtable.New(store, qtable.DefaultConfig())
+	a.SetBoard(b)
+	a.ExploreCurrentMoves(b.GetValidMoves(), b.ToString())
+
+	state, err := store.Get(b.ToString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := qtable.Seed(b, qtable.Broadcast); state.Reward != want || len(state.Children) != len(b.GetValidMoves()) {
+		t.Errorf("root stored with reward %v and %v children, want %v and %v", state.Reward, len(state.Children), want, len(b.GetValidMoves()))
+	}
+}
+
+func TestOpenUnmigrated(t *testing.T) {
+	b := oware.Initialize()
+	opening, err := b.Move(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		refused bool
+	}{
+		{name: "canonical", key: b.ToString()},
+		{name: "player 1 to move", key: opening.ToString(), refused: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "qtable.db")
+			raw, err := storage.OpenRaw("disk", path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := raw.Insert(tt.key, &storage.OwareState{}); err != nil {
+				t.Fatal(err)
+			}
+			raw.Close()
+
+			store, err := storage.Open("disk", path, 0)
+			if tt.refused {
+				if !errors.Is(err, storage.ErrNotCanonical) {
+					t.Errorf("opened an unmigrated store: %v", err)
+				}
+				if err == nil {
+					store.Close()
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if _, err := store.Get(tt.key); err != nil {
+				t.Errorf("%s: %v", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	b := seeded(t)
+	key := b.ToString()
+	merge := qtable.MergeReward(qtable.QLearning)
+
+	from := storage.NewMemory(0)
+	defer from.Close()
+	for _, k := range []string{key, mirror(t, key)} {
+		if err := from.Insert(k, &storage.OwareState{Reward: 1, Games: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// States the canonical store already has are merged too, not skipped
+	to := storage.NewCanonical(storage.NewMemory(0), 0)
+	defer to.Close()
+	if err := to.Insert(key, &storage.OwareState{Reward: -1, Games: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, merged, err := storage.Migrate(from, to, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 || merged != 2 {
+		t.Errorf("inserted %v and merged %v states, want 2 merged", inserted, merged)
+	}
+
+	state, err := to.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Reward != 0 || state.Games != 4 {
+		t.Errorf("got reward %v after %v games, want 0 after 4", state.Reward, state.Games)
+	}
+}
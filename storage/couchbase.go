@@ -15,19 +15,14 @@ const (
 	bucket = "qlearn"
 )
 
-type Storage struct {
+// Couchbase stores states in the "qlearn" bucket with one scope per player
+type Couchbase struct {
+	channels
+	scopes      map[string]*gocb.Scope
 	collections map[string]*gocb.Collection
-	RewardChan  chan string
-	PunishChan  chan string
 }
 
-type OwareState struct {
-	Reward   int
-	Children []string
-	Games    int
-}
-
-func Init(workers int) (*Storage, error) {
+func InitCouchbase(workers int) (*Couchbase, error) {
 	cluster, err := gocb.Connect(
 		"localhost",
 		gocb.ClusterOptions{
@@ -45,48 +40,45 @@ func Init(workers int) (*Storage, error) {
 		return nil, err
 	}
 
+	scopes := make(map[string]*gocb.Scope, 2)
 	collections := make(map[string]*gocb.Collection, 2)
 
 	// Player 0 collection
 	sc0 := bucket.Scope("0")
 	collection0 := sc0.Collection("0")
+	scopes["0"] = sc0
 	collections["0"] = collection0
 
 	// Player 1 collection
 	sc1 := bucket.Scope("1")
 	collection1 := sc1.Collection("1")
+	scopes["1"] = sc1
 	collections["1"] = collection1
 
-	rewardChan := make(chan string)
-	punishChan := make(chan string)
-	s := &Storage{
+	s := &Couchbase{
+		scopes:      scopes,
 		collections: collections,
-		RewardChan:  rewardChan,
-		PunishChan:  punishChan,
 	}
 
 	// Initialize workers
-	s.processRewards(workers)
+	s.channels = newChannels(s, workers)
 
 	return s, nil
 }
 
-func (s *Storage) Close() {
-	close(s.PunishChan)
-	close(s.RewardChan)
-	fmt.Println("Closed storage channels")
+func (s *Couchbase) Close() {
+	s.channels.close()
 }
 
-func (s *Storage) Get(key string) (*OwareState, error) {
-	c, exists := s.collections[key[2:3]]
-	if !exists {
-		return nil, errors.New("collection doesn't exist")
+func (s *Couchbase) Get(key string) (*OwareState, error) {
+	c, err := s.collection(key)
+	if err != nil {
+		return nil, err
 	}
 
 	retry := true
 	retries := 1
 	var r *gocb.GetResult
-	var err error
 	for retry {
 		r, err = c.Get(key, nil)
 		if err == nil {
@@ -94,6 +86,10 @@ func (s *Storage) Get(key string) (*OwareState, error) {
 			continue
 		}
 
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			return nil, ErrNotFound
+		}
+
 		switch err.(type) {
 		case *gocb.KeyValueError:
 			return nil, err
@@ -116,8 +112,12 @@ func (s *Storage) Get(key string) (*OwareState, error) {
 	return &state, nil
 }
 
-func (s *Storage) GetAndLock(key string) (*OwareState, gocb.Cas, error) {
-	r, _ := s.retryGetAndLock(key, time.Second*15)
+func (s *Couchbase) GetAndLock(key string) (*OwareState, gocb.Cas, error) {
+	r, err := s.retryGetAndLock(key, time.Second*15)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	cas := r.Cas()
 
 	var state OwareState
@@ -129,7 +129,7 @@ func (s *Storage) GetAndLock(key string) (*OwareState, gocb.Cas, error) {
 	return &state, cas, nil
 }
 
-func (s *Storage) SafeAddChildren(key string, children []string) error {
+func (s *Couchbase) SafeAddChildren(key string, children []string) error {
 	state, cas, err := s.GetAndLock(key)
 	defer s.unlock(key, cas)
 	if err != nil {
@@ -144,7 +144,7 @@ func (s *Storage) SafeAddChildren(key string, children []string) error {
 	return s.Replace(key, cas, state)
 }
 
-func (s *Storage) SafeAdjustReward(key string, adjustment int) error {
+func (s *Couchbase) SafeAdjustReward(key string, adjustment float64) error {
 	state, cas, err := s.GetAndLock(key)
 	defer s.unlock(key, cas)
 	if err != nil {
@@ -157,16 +157,29 @@ func (s *Storage) SafeAdjustReward(key string, adjustment int) error {
 	return s.Replace(key, cas, state)
 }
 
-func (s *Storage) Replace(key string, cas gocb.Cas, state *OwareState) error {
-	c, exists := s.collections[key[2:3]]
-	if !exists {
-		return errors.New("collection doesn't exist")
+func (s *Couchbase) SafeUpdateReward(key string, target float64, alpha float64) error {
+	state, cas, err := s.GetAndLock(key)
+	defer s.unlock(key, cas)
+	if err != nil {
+		fmt.Printf("failing to update reward. key: %s, cas: %v\n", key, cas)
+		return err
+	}
+
+	state.Reward += alpha * (target - state.Reward)
+	state.Games++
+	return s.Replace(key, cas, state)
+}
+
+func (s *Couchbase) Replace(key string, cas gocb.Cas, state *OwareState) error {
+	c, err := s.collection(key)
+	if err != nil {
+		return err
 	}
 
 	retry := true
 	retries := 1
 	for retry {
-		_, err := c.Replace(key, state, &gocb.ReplaceOptions{Cas: cas})
+		_, err = c.Replace(key, state, &gocb.ReplaceOptions{Cas: cas})
 		if err == nil {
 			return nil
 		}
@@ -181,22 +194,22 @@ func (s *Storage) Replace(key string, cas gocb.Cas, state *OwareState) error {
 	return errors.New("failed to replace. loop exited")
 }
 
-func (s *Storage) Insert(key string, state *OwareState) error {
-	c, exists := s.collections[key[2:3]]
-	if !exists {
-		return errors.New("collection doesn't exist")
+func (s *Couchbase) Insert(key string, state *OwareState) error {
+	c, err := s.collection(key)
+	if err != nil {
+		return err
 	}
 
 	retry := true
 	retries := 1
 	for retry {
-		_, err := c.Insert(key, state, nil)
+		_, err = c.Insert(key, state, nil)
 		if err == nil {
 			return nil
 		}
 
-		if err == gocb.ErrDocumentExists {
-			return err
+		if errors.Is(err, gocb.ErrDocumentExists) {
+			return ErrExists
 		}
 
 		switch err.(type) {
@@ -215,41 +228,153 @@ func (s *Storage) Insert(key string, state *OwareState) error {
 	return errors.New("failed to update. loop exited")
 }
 
-func (s *Storage) processRewards(workers int) {
-	for w := 1; w <= workers/2; w++ {
-		go s.adjust(w, 1, s.RewardChan)
+// Range streams every state with a N1QL query. Requires a primary index on both collections.
+func (s *Couchbase) Range(fn func(key string, state *OwareState) error) error {
+	for _, name := range []string{"0", "1"} {
+		if err := s.rangeScope(name, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Couchbase) rangeScope(name string, fn func(key string, state *OwareState) error) error {
+	statement := fmt.Sprintf("SELECT META(c).id AS Key, c AS State FROM `%s` AS c", name)
+	rows, err := s.scopes[name].Query(statement, nil)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	for w := 1; w <= workers/2; w++ {
-		go s.adjust(w, -1, s.PunishChan)
+	for rows.Next() {
+		var row struct {
+			Key   string
+			State OwareState
+		}
+		if err := rows.Row(&row); err != nil {
+			return err
+		}
+
+		if err := fn(row.Key, &row.State); err != nil {
+			return err
+		}
 	}
+
+	return rows.Err()
 }
 
-func (s *Storage) adjust(id int, reward int, moves <-chan string) {
-	for m := range moves {
-		if err := s.SafeAdjustReward(m, reward); err != nil {
-			fmt.Printf("failed to save reward: %s\n", m)
+// hasMirrored reports whether scope "1" still holds the positions after the first move, which
+// every game trained before keys were canonical stored. It only uses key-value lookups.
+func (s *Couchbase) hasMirrored() (bool, error) {
+	for _, key := range openingKeys() {
+		_, err := s.Get(key)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return false, err
 		}
 	}
+
+	return false, nil
 }
 
-func (s *Storage) unlock(key string, cas gocb.Cas) {
+// Fold migrates the bucket to canonical keys in place: every state in scope "1" is merged into
+// the state of its mirror in scope "0" and removed, one at a time. The positions after the first
+// move are folded last so hasMirrored holds until everything else is done. An interrupted fold
+// can be run again, only a state that was merged but not removed yet is counted twice.
+// It returns how many states were folded.
+func (s *Couchbase) Fold(merge MergeReward) (int, error) {
+	openings := make(map[string]bool)
+	for _, key := range openingKeys() {
+		openings[key] = true
+	}
+
+	folded := 0
+	last := make(map[string]*OwareState)
+	// Folding writes to the scopes while the query streams, the query doesn't see the changes
+	err := s.rangeScope("1", func(key string, state *OwareState) error {
+		if openings[key] {
+			last[key] = state
+			return nil
+		}
+
+		if err := s.foldOne(key, state, merge); err != nil {
+			return err
+		}
+		folded++
+		return nil
+	})
+	if err != nil {
+		return folded, err
+	}
+
+	for key, state := range last {
+		if err := s.foldOne(key, state, merge); err != nil {
+			return folded, err
+		}
+		folded++
+	}
+
+	return folded, nil
+}
+
+// foldOne merges a state from scope "1" into its mirror and removes it
+func (s *Couchbase) foldOne(key string, state *OwareState, merge MergeReward) error {
+	ckey, flipped := CanonicalKey(key)
+	if !flipped {
+		return fmt.Errorf("invalid key in scope 1: %s", key)
+	}
+
+	if _, err := s.SafeMerge(ckey, orient(state, flipped), merge); err != nil {
+		return err
+	}
+
+	_, err := s.collections["1"].Remove(key, nil)
+	return err
+}
+
+// SafeMerge inserts state, or merges it into the stored state when the key exists
+func (s *Couchbase) SafeMerge(key string, state *OwareState, merge MergeReward) (bool, error) {
+	_, err := s.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		err = s.Insert(key, state)
+		if !errors.Is(err, ErrExists) {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	existing, cas, err := s.GetAndLock(key)
+	defer s.unlock(key, cas)
+	if err != nil {
+		return false, err
+	}
+
+	mergeState(key, existing, state, merge)
+	return true, s.Replace(key, cas, existing)
+}
+
+func (s *Couchbase) unlock(key string, cas gocb.Cas) {
 	if cas == 0 {
 		return
 	}
 
-	c, exists := s.collections[key[2:3]]
-	if !exists {
+	c, err := s.collection(key)
+	if err != nil {
 		fmt.Printf("FAILED TO UNLOCK. %s, %v", key, cas)
+		return
 	}
 
 	c.Unlock(key, cas, nil)
 }
 
-func (s *Storage) retryGetAndLock(key string, timeout time.Duration) (*gocb.GetResult, error) {
-	c, exists := s.collections[key[2:3]]
-	if !exists {
-		return nil, errors.New("collection doesn't exist")
+func (s *Couchbase) retryGetAndLock(key string, timeout time.Duration) (*gocb.GetResult, error) {
+	c, err := s.collection(key)
+	if err != nil {
+		return nil, err
 	}
 
 	retry := true
@@ -269,3 +394,17 @@ func (s *Storage) retryGetAndLock(key string, timeout time.Duration) (*gocb.GetR
 
 	return nil, errors.New("failed to retry and lock. loop exited")
 }
+
+func (s *Couchbase) collection(key string) (*gocb.Collection, error) {
+	p, err := partition(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c, exists := s.collections[p]
+	if !exists {
+		return nil, errors.New("collection doesn't exist")
+	}
+
+	return c, nil
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	diskMagic   = "OWARELOG"
+	diskVersion = uint16(1)
+	// Rewrite the log once it holds this many records per live state
+	compactRatio = 4
+	minCompact   = 1000000
+	maxRecord    = 1 << 24
+)
+
+// Disk keeps states in memory and persists every change to a single append-only log file.
+// The log is replayed on open and compacted to one record per state on close.
+type Disk struct {
+	*Memory
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	records int
+	done    chan struct{}
+}
+
+type diskRecord struct {
+	Key   string
+	State *OwareState
+}
+
+func OpenDisk(path string, workers int) (*Disk, error) {
+	d := &Disk{
+		Memory: NewMemory(workers),
+		path:   path,
+		done:   make(chan struct{}),
+	}
+
+	if err := d.load(); err != nil {
+		d.Memory.Close()
+		return nil, err
+	}
+
+	d.Memory.persist = d.append
+	go d.flushPeriodically(time.Second)
+
+	return d, nil
+}
+
+func (d *Disk) Close() {
+	d.Memory.Close()
+	close(d.done)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.compact(); err != nil {
+		fmt.Printf("failed to compact %s. %v\n", d.path, err)
+		d.w.Flush()
+	}
+
+	d.file.Close()
+	d.Memory.persist = func(key string, state *OwareState) error {
+		return errors.New("storage is closed")
+	}
+}
+
+// load replays the log into memory, dropping a torn record at the tail.
+// A corrupt record in the middle of the log fails the load instead of losing what follows.
+func (d *Disk) load() error {
+	f, err := os.OpenFile(d.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if info.Size() == 0 {
+		if err := writeDiskHeader(f); err != nil {
+			f.Close()
+			return err
+		}
+	} else {
+		offset, err := d.replay(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		if offset < info.Size() {
+			fmt.Printf("dropping %v bytes of incomplete records from %s\n", info.Size()-offset, d.path)
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	d.file = f
+	d.w = bufio.NewWriter(f)
+	return nil
+}
+
+func (d *Disk) replay(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+	if err := readDiskHeader(r); err != nil {
+		return 0, err
+	}
+
+	offset := int64(len(diskMagic) + 2)
+	for {
+		rec, n, err := readDiskRecord(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// EOF or a torn write, everything before offset is valid
+			return offset, nil
+		}
+		if err != nil {
+			// A bad last record is a torn write too, anything followed by more data is corruption
+			if _, perr := r.Peek(1); perr == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("corrupt record at offset %v in %s: %w", offset, d.path, err)
+		}
+
+		p, err := d.Memory.partition(rec.Key)
+		if err != nil {
+			return offset, err
+		}
+
+		p[rec.Key] = rec.State
+		d.records++
+		offset += n
+	}
+}
+
+// append logs a state. Called with the memory lock held.
+func (d *Disk) append(key string, state *OwareState) error {
+	if err := writeDiskRecord(d.w, &diskRecord{Key: key, State: state}); err != nil {
+		return err
+	}
+
+	d.records++
+	if d.records > minCompact && d.records > compactRatio*d.live() {
+		return d.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the log with the latest record of every state. Called with the memory lock held.
+func (d *Disk) compact() error {
+	if err := d.w.Flush(); err != nil {
+		return err
+	}
+
+	tmpPath := d.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	records, err := d.writeAll(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, d.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	d.file.Close()
+	d.file = f
+	d.w = bufio.NewWriter(f)
+	d.records = records
+	return nil
+}
+
+func (d *Disk) writeAll(w io.Writer) (int, error) {
+	if err := writeDiskHeader(w); err != nil {
+		return 0, err
+	}
+
+	records := 0
+	for _, name := range []string{"0", "1"} {
+		for k, v := range d.partitions[name] {
+			if err := writeDiskRecord(w, &diskRecord{Key: k, State: v}); err != nil {
+				return records, err
+			}
+			records++
+		}
+	}
+
+	return records, nil
+}
+
+func (d *Disk) live() int {
+	total := 0
+	for _, p := range d.partitions {
+		total += len(p)
+	}
+
+	return total
+}
+
+func (d *Disk) flushPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			if err := d.w.Flush(); err != nil {
+				fmt.Printf("failed to flush %s. %v\n", d.path, err)
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+func writeDiskHeader(w io.Writer) error {
+	header := make([]byte, len(diskMagic)+2)
+	copy(header, diskMagic)
+	binary.BigEndian.PutUint16(header[len(diskMagic):], diskVersion)
+	_, err := w.Write(header)
+	return err
+}
+
+func readDiskHeader(r io.Reader) error {
+	header := make([]byte, len(diskMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read log header: %w", err)
+	}
+
+	if string(header[:len(diskMagic)]) != diskMagic {
+		return errors.New("not an oware log file")
+	}
+
+	if v := binary.BigEndian.Uint16(header[len(diskMagic):]); v != diskVersion {
+		return fmt.Errorf("unsupported log version: %v", v)
+	}
+
+	return nil
+}
+
+// Records are a 4 byte length, a 4 byte crc32 of the payload and a json payload
+func writeDiskRecord(w io.Writer, rec *diskRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint32(prefix, uint32(len(payload)))
+	binary.BigEndian.PutUint32(prefix[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+
+	_, err = w.Write(payload)
+	return err
+}
+
+func readDiskRecord(r io.Reader) (*diskRecord, int64, error) {
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix)
+	if size > maxRecord {
+		return nil, 0, errors.New("record too large")
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(prefix[4:]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	var rec diskRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, 0, err
+	}
+
+	if rec.State == nil {
+		return nil, 0, errors.New("record has no state")
+	}
+
+	return &rec, int64(len(prefix) + len(payload)), nil
+}
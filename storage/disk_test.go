@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Antonite/oware"
+)
+
+// testKeys returns a few positions from the start of a game with either player to move
+func testKeys(t *testing.T) []string {
+	b := oware.Initialize()
+	keys := []string{b.ToString()}
+	for _, m := range b.GetValidMoves() {
+		child, err := b.Move(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, child.ToString())
+	}
+
+	return keys
+}
+
+func openTestDisk(t *testing.T, path string) *Disk {
+	d, err := OpenDisk(path, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+
+	return d
+}
+
+// writeTestDisk inserts every key with its index as reward, adjusts the first one and closes the log
+func writeTestDisk(t *testing.T, path string, keys []string) {
+	d := openTestDisk(t, path)
+	for i, k := range keys {
+		if err := d.Insert(k, &OwareState{Reward: float64(i), Children: []string{keys[0]}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := d.SafeAdjustReward(keys[0], 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.Close()
+}
+
+func checkTestDisk(t *testing.T, d *Disk, keys []string) {
+	for i, k := range keys {
+		state, err := d.Get(k)
+		if err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+
+		reward, games := float64(i), 0
+		if i == 0 {
+			reward, games = 3, 3
+		}
+		if state.Reward != reward || state.Games != games || len(state.Children) != 1 || state.Children[0] != keys[0] {
+			t.Errorf("%s: got %+v, want reward %v after %v games", k, state, reward, games)
+		}
+	}
+}
+
+func TestDiskReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qtable.db")
+	keys := testKeys(t)
+	writeTestDisk(t, path, keys)
+
+	d := openTestDisk(t, path)
+	defer d.Close()
+	checkTestDisk(t, d, keys)
+}
+
+func TestDiskTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qtable.db")
+	keys := testKeys(t)
+	writeTestDisk(t, path, keys[1:])
+
+	// A torn append of one more state, as if the process died mid write
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDiskRecord(f, &diskRecord{Key: keys[0], State: &OwareState{}}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(info.Size() - 3); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d := openTestDisk(t, path)
+	if _, err := d.Get(keys[0]); err != ErrNotFound {
+		t.Errorf("torn record was loaded: %v", err)
+	}
+	for _, k := range keys[1:] {
+		if _, err := d.Get(k); err != nil {
+			t.Errorf("%s: %v", k, err)
+		}
+	}
+
+	// The torn tail is cut off so later appends are readable
+	if err := d.Insert(keys[0], &OwareState{Reward: 5}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = openTestDisk(t, path)
+	defer d.Close()
+	if state, err := d.Get(keys[0]); err != nil || state.Reward != 5 {
+		t.Errorf("state appended after truncation: got %+v, %v", state, err)
+	}
+}
+
+func TestDiskCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qtable.db")
+	writeTestDisk(t, path, testKeys(t))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte in the payload of the first record, more records follow it
+	data[len(diskMagic)+2+8+1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err := OpenDisk(path, 0); err == nil {
+		d.Close()
+		t.Fatal("opened a log with a corrupt record before its tail")
+	}
+}
+
+func TestDiskCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qtable.db")
+	keys := testKeys(t)
+
+	d := openTestDisk(t, path)
+	for i, k := range keys {
+		if err := d.Insert(k, &OwareState{Reward: float64(i), Children: []string{keys[0]}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := d.SafeAdjustReward(keys[0], 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.mu.Lock()
+	err := d.compact()
+	records := d.records
+	d.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != len(keys) {
+		t.Errorf("compacted log has %v records, want %v", records, len(keys))
+	}
+	checkTestDisk(t, d, keys)
+
+	// Writes after compaction go to the new log
+	if err := d.SafeAdjustReward(keys[1], 1); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = openTestDisk(t, path)
+	defer d.Close()
+	if state, err := d.Get(keys[1]); err != nil || state.Reward != 2 || state.Games != 1 {
+		t.Errorf("state written after compaction: got %+v, %v", state, err)
+	}
+	if d.records != len(keys) {
+		t.Errorf("reopened log has %v records, want %v", d.records, len(keys))
+	}
+}
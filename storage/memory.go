@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+// Memory keeps states in process, partitioned by player like the Couchbase collections
+type Memory struct {
+	channels
+	mu         sync.RWMutex
+	partitions map[string]map[string]*OwareState
+	// persist is called with every changed state while the lock is held
+	persist func(key string, state *OwareState) error
+}
+
+func NewMemory(workers int) *Memory {
+	s := &Memory{
+		partitions: map[string]map[string]*OwareState{
+			"0": make(map[string]*OwareState),
+			"1": make(map[string]*OwareState),
+		},
+	}
+
+	// Initialize workers
+	s.channels = newChannels(s, workers)
+
+	return s
+}
+
+func (s *Memory) Close() {
+	s.channels.close()
+}
+
+func (s *Memory) Get(key string) (*OwareState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, err := s.partition(key)
+	if err != nil {
+		return nil, err
+	}
+
+	state, exists := p[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return copyState(state), nil
+}
+
+func (s *Memory) Insert(key string, state *OwareState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.partition(key)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := p[key]; exists {
+		return ErrExists
+	}
+
+	p[key] = copyState(state)
+	return s.save(key, p[key])
+}
+
+func (s *Memory) SafeAddChildren(key string, children []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.get(key)
+	if err != nil {
+		return err
+	}
+
+	if len(state.Children) > 0 {
+		return nil
+	}
+
+	state.Children = append([]string{}, children...)
+	return s.save(key, state)
+}
+
+func (s *Memory) SafeAdjustReward(key string, adjustment float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.get(key)
+	if err != nil {
+		return err
+	}
+
+	state.Reward += adjustment
+	state.Games++
+	return s.save(key, state)
+}
+
+func (s *Memory) SafeUpdateReward(key string, target float64, alpha float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.get(key)
+	if err != nil {
+		return err
+	}
+
+	state.Reward += alpha * (target - state.Reward)
+	state.Games++
+	return s.save(key, state)
+}
+
+func (s *Memory) SafeMerge(key string, state *OwareState, merge MergeReward) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.partition(key)
+	if err != nil {
+		return false, err
+	}
+
+	existing, exists := p[key]
+	if !exists {
+		p[key] = copyState(state)
+		return false, s.save(key, p[key])
+	}
+
+	mergeState(key, existing, state, merge)
+	return true, s.save(key, existing)
+}
+
+func (s *Memory) save(key string, state *OwareState) error {
+	if s.persist == nil {
+		return nil
+	}
+
+	return s.persist(key, state)
+}
+
+func (s *Memory) Range(fn func(key string, state *OwareState) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, name := range []string{"0", "1"} {
+		for k, v := range s.partitions[name] {
+			if err := fn(k, copyState(v)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasMirrored reports whether any key has player 1 to move
+func (s *Memory) hasMirrored() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.partitions["1"]) > 0, nil
+}
+
+// get returns the stored state itself. Callers must hold the lock.
+func (s *Memory) get(key string) (*OwareState, error) {
+	p, err := s.partition(key)
+	if err != nil {
+		return nil, err
+	}
+
+	state, exists := p[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return state, nil
+}
+
+func (s *Memory) partition(key string) (map[string]*OwareState, error) {
+	name, err := partition(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p, exists := s.partitions[name]
+	if !exists {
+		return nil, errors.New("collection doesn't exist")
+	}
+
+	return p, nil
+}
+
+func copyState(state *OwareState) *OwareState {
+	return &OwareState{
+		Reward:   state.Reward,
+		Children: append([]string{}, state.Children...),
+		Games:    state.Games,
+	}
+}
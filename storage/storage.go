@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound = errors.New("state doesn't exist")
+	ErrExists   = errors.New("state already exists")
+	// ErrNotCanonical is returned by Open for stores with keys from before they were canonical
+	ErrNotCanonical = errors.New("store has states with player 1 to move, run qtable migrate first")
+)
+
+// Storage is a store of learned oware states keyed by board string.
+// Keys are partitioned by the player to move (key[2:3]).
+// Stores returned by Open are Canonical and only hold keys with player 0 to move.
+type Storage interface {
+	Get(key string) (*OwareState, error)
+	Insert(key string, state *OwareState) error
+	SafeAddChildren(key string, children []string) error
+	SafeAdjustReward(key string, adjustment float64) error
+	// SafeUpdateReward moves the reward a step of size alpha toward target
+	SafeUpdateReward(key string, target float64, alpha float64) error
+	// SafeMerge inserts state, or folds it into the stored state with merge when the key exists.
+	// It reports whether the state was merged.
+	SafeMerge(key string, state *OwareState, merge MergeReward) (bool, error)
+	// Range calls fn for every stored state until fn returns an error.
+	// fn must not call back into the store.
+	Range(fn func(key string, state *OwareState) error) error
+	// RewardChan and PunishChan queue keys for asynchronous reward adjustment
+	RewardChan() chan<- string
+	PunishChan() chan<- string
+	Close()
+}
+
+// Open initializes the named storage backend with canonical keys. Path is only used by the disk backend.
+func Open(backend string, path string, workers int) (Storage, error) {
+	s, err := OpenRaw(backend, path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records with player 1 to move would never be found, they have to be migrated first
+	if raw, ok := s.(interface{ hasMirrored() (bool, error) }); ok {
+		mirrored, err := raw.hasMirrored()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if mirrored {
+			s.Close()
+			return nil, ErrNotCanonical
+		}
+	}
+
+	return NewCanonical(s, workers), nil
+}
+
+// OpenRaw initializes the named storage backend keyed by board strings as they are given,
+// for stores written before keys were canonical
+func OpenRaw(backend string, path string, workers int) (Storage, error) {
+	switch backend {
+	case "couchbase":
+		s, err := InitCouchbase(workers)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "memory":
+		return NewMemory(workers), nil
+	case "disk":
+		s, err := OpenDisk(path, workers)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+type OwareState struct {
+	Reward   float64
+	Children []string
+	Games    int
+}
+
+// channels feeds reward and punish keys to SafeAdjustReward workers
+type channels struct {
+	rewardChan chan string
+	punishChan chan string
+}
+
+func newChannels(s Storage, workers int) channels {
+	c := channels{
+		rewardChan: make(chan string),
+		punishChan: make(chan string),
+	}
+
+	for w := 1; w <= workers/2; w++ {
+		go adjust(s, 1, c.rewardChan)
+	}
+
+	for w := 1; w <= workers/2; w++ {
+		go adjust(s, -1, c.punishChan)
+	}
+
+	return c
+}
+
+func (c *channels) RewardChan() chan<- string {
+	return c.rewardChan
+}
+
+func (c *channels) PunishChan() chan<- string {
+	return c.punishChan
+}
+
+func (c *channels) close() {
+	close(c.punishChan)
+	close(c.rewardChan)
+	fmt.Println("Closed storage channels")
+}
+
+func adjust(s Storage, reward float64, moves <-chan string) {
+	for m := range moves {
+		if err := s.SafeAdjustReward(m, reward); err != nil {
+			fmt.Printf("failed to save reward: %s\n", m)
+		}
+	}
+}
+
+// partition returns the player partition of a board key
+func partition(key string) (string, error) {
+	if len(key) < 3 {
+		return "", errors.New("invalid key")
+	}
+
+	return key[2:3], nil
+}